@@ -0,0 +1,26 @@
+package main
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "strings"
+)
+
+// requireAdmin checks the bearer token from ADMIN_TOKEN. Admin endpoints are
+// disabled entirely when it is unset.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if s.config.AdminToken == "" {
+            writeError(w, http.StatusForbidden, "admin API is disabled")
+            return
+        }
+
+        token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            writeError(w, http.StatusUnauthorized, "invalid admin token")
+            return
+        }
+        next(w, r)
+    }
+}
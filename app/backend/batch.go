@@ -0,0 +1,243 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+
+    "github.com/google/uuid"
+    "gorm.io/gorm"
+)
+
+// Upper bound on the operations in one POST /todos/batch
+const maxBatchOperations = 100
+
+type batchOperation struct {
+    Op   string `json:"op"`
+    UUID string `json:"uuid"`
+    // The todo to create, or the fields to change for an update (as PATCH)
+    Todo json.RawMessage `json:"todo"`
+}
+
+type batchResult struct {
+    Status int    `json:"status"`
+    Todo   *Todo  `json:"todo,omitempty"`
+    Error  string `json:"error,omitempty"`
+}
+
+// batchFailure is an operation that failed with a client-facing status
+type batchFailure struct {
+    status  int
+    message string
+}
+
+func (f *batchFailure) Error() string {
+    return f.message
+}
+
+func batchFail(status int, format string, args ...interface{}) error {
+    return &batchFailure{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// batchTodos runs a list of create, update and delete operations in order and
+// answers with one result per operation. By default each operation commits on
+// its own; with ?atomic=true they share a transaction and the first failure
+// rolls all of them back.
+func (s *Server) batchTodos(w http.ResponseWriter, r *http.Request) {
+    atomic := false
+    if value := r.URL.Query().Get("atomic"); value != "" {
+        var err error
+        if atomic, err = strconv.ParseBool(value); err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid atomic value %q", value))
+            return
+        }
+    }
+
+    var ops []batchOperation
+    if err := decodeJSON(r, &ops); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(ops) == 0 {
+        writeError(w, http.StatusBadRequest, "at least one operation is required")
+        return
+    }
+    if len(ops) > maxBatchOperations {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d operations can be sent at once", maxBatchOperations))
+        return
+    }
+
+    results := make([]batchResult, len(ops))
+    run := func(tx *gorm.DB, i int) error {
+        status, todo, err := s.applyBatchOperation(tx, r, ops[i])
+        if err != nil {
+            return err
+        }
+        results[i] = batchResult{Status: status, Todo: todo}
+        return nil
+    }
+    fail := func(i int, err error) {
+        var failure *batchFailure
+        if errors.As(err, &failure) {
+            results[i] = batchResult{Status: failure.status, Error: failure.message}
+        } else {
+            results[i] = batchResult{Status: http.StatusInternalServerError, Error: err.Error()}
+        }
+    }
+
+    if !atomic {
+        for i := range ops {
+            if err := s.db.Transaction(func(tx *gorm.DB) error { return run(tx, i) }); err != nil {
+                fail(i, err)
+            }
+        }
+        writeJSON(w, http.StatusOK, results)
+        return
+    }
+
+    failed := -1
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        for i := range ops {
+            if err := run(tx, i); err != nil {
+                failed = i
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil && failed < 0 {
+        // The commit itself failed
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if failed >= 0 {
+        for i := range results {
+            if i != failed {
+                results[i] = batchResult{
+                    Status: http.StatusFailedDependency,
+                    Error:  fmt.Sprintf("not applied, operation %d failed", failed),
+                }
+            }
+        }
+        fail(failed, err)
+    }
+    writeJSON(w, http.StatusOK, results)
+}
+
+// applyBatchOperation runs one operation with the same rules as the matching
+// single-todo endpoint
+func (s *Server) applyBatchOperation(tx *gorm.DB, r *http.Request, op batchOperation) (int, *Todo, error) {
+    switch op.Op {
+    case "create":
+        return s.batchCreate(tx, r, op)
+    case "update":
+        return s.batchUpdate(tx, op)
+    case "delete":
+        return s.batchDelete(tx, op)
+    }
+    return 0, nil, batchFail(http.StatusBadRequest, "invalid op %q, must be create, update or delete", op.Op)
+}
+
+func (s *Server) batchCreate(tx *gorm.DB, r *http.Request, op batchOperation) (int, *Todo, error) {
+    if len(op.Todo) == 0 {
+        return 0, nil, batchFail(http.StatusBadRequest, "todo is required for create")
+    }
+    var todo Todo
+    if err := json.Unmarshal(op.Todo, &todo); err != nil {
+        return 0, nil, batchFail(http.StatusBadRequest, "%s", describeDecodeError(err))
+    }
+    tags, err := s.validateNewTodo(r, &todo)
+    if err != nil {
+        return 0, nil, batchFail(http.StatusBadRequest, "%s", err)
+    }
+
+    todo.Model = Model{}
+    todo.Tags = nil
+    todo.OwnerID = ""
+    todo.CompletedAt = completedAt(todo.Completed)
+    if todo.UUID != "" {
+        parsed, err := uuid.Parse(todo.UUID)
+        if err != nil {
+            return 0, nil, batchFail(http.StatusBadRequest, "uuid must be a valid UUID")
+        }
+        todo.UUID = parsed.String()
+    } else {
+        todo.UUID = s.newTodoUUID()
+    }
+
+    if err := tx.Create(&todo).Error; err != nil {
+        if errors.Is(err, gorm.ErrDuplicatedKey) {
+            return 0, nil, batchFail(http.StatusConflict, "a todo with this uuid already exists")
+        }
+        return 0, nil, err
+    }
+    if len(tags) > 0 {
+        if err := setTodoTags(tx, &todo, tags); err != nil {
+            return 0, nil, err
+        }
+    }
+    return http.StatusCreated, &todo, nil
+}
+
+func (s *Server) batchUpdate(tx *gorm.DB, op batchOperation) (int, *Todo, error) {
+    if op.UUID == "" {
+        return 0, nil, batchFail(http.StatusBadRequest, "uuid is required for update")
+    }
+    var body map[string]json.RawMessage
+    if err := json.Unmarshal(op.Todo, &body); err != nil || len(body) == 0 {
+        return 0, nil, batchFail(http.StatusBadRequest, "todo must be an object with at least one field to update")
+    }
+    updates, err := parseTodoPatch(body)
+    if err != nil {
+        return 0, nil, batchFail(http.StatusBadRequest, "%s", err)
+    }
+    // Map updates skip gorm serializers, so seal the description here
+    if description, ok := updates["description"].(string); ok {
+        if err := s.checkDescription(description); err != nil {
+            return 0, nil, batchFail(http.StatusBadRequest, "%s", err)
+        }
+        if updates["description"], err = s.config.FieldCipher.seal(description); err != nil {
+            return 0, nil, err
+        }
+    }
+
+    var todo Todo
+    err = tx.Where("uuid = ?", op.UUID).First(&todo).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return 0, nil, batchFail(http.StatusNotFound, "todo not found")
+    }
+    if err != nil {
+        return 0, nil, err
+    }
+    if err := tx.Model(&todo).Updates(updates).Error; err != nil {
+        return 0, nil, err
+    }
+    // Reload for the stored values of expressions such as completed_at
+    if err := tx.Preload("Tags").Where("id = ?", todo.ID).First(&todo).Error; err != nil {
+        return 0, nil, err
+    }
+    return http.StatusOK, &todo, nil
+}
+
+func (s *Server) batchDelete(tx *gorm.DB, op batchOperation) (int, *Todo, error) {
+    if op.UUID == "" {
+        return 0, nil, batchFail(http.StatusBadRequest, "uuid is required for delete")
+    }
+    // Comments and drafts go with their todo, as in deleteTodo
+    result := tx.Where("uuid = ?", op.UUID).Delete(&Todo{})
+    if result.Error != nil {
+        return 0, nil, result.Error
+    }
+    if result.RowsAffected == 0 {
+        return 0, nil, batchFail(http.StatusNotFound, "todo not found")
+    }
+    if err := tx.Where("todo_uuid = ?", op.UUID).Delete(&Comment{}).Error; err != nil {
+        return 0, nil, err
+    }
+    if err := tx.Where("todo_uuid = ?", op.UUID).Delete(&Draft{}).Error; err != nil {
+        return 0, nil, err
+    }
+    return http.StatusNoContent, nil, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Upper bound on the number of UUIDs accepted by batch endpoints
+const maxBatchUUIDs = 100
+
+// setAllCompleted marks every todo matching the list filters as completed or
+// not in a single UPDATE.
+func (s *Server) setAllCompleted(completed bool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        filters, err := s.parseTodoFilters(r)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+
+        var updated int64
+        err = s.db.Transaction(func(tx *gorm.DB) error {
+            // Only touch rows that actually change so the count is meaningful
+            result := tx.Model(&Todo{}).Scopes(filters).
+                Where("completed <> ?", completed).
+                Updates(map[string]interface{}{"completed": completed, "completed_at": completedAt(completed)})
+            updated = result.RowsAffected
+            return result.Error
+        })
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]int64{"updated": updated})
+    }
+}
+
+// archiveTodos archives every todo matching the list filters. Like
+// patchTodos it needs at least one filter, so the whole list can't be archived
+// by accident.
+func (s *Server) archiveTodos(w http.ResponseWriter, r *http.Request) {
+    if !hasTodoFilters(r) {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at least one filter is required (%s)", strings.Join(todoFilterParams, ", ")))
+        return
+    }
+    filters, err := s.parseTodoFilters(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    var archived int64
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        result := tx.Model(&Todo{}).Scopes(filters).
+            Where("archived = ?", false).
+            Update("archived", true)
+        archived = result.RowsAffected
+        return result.Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]int64{"archived": archived})
+}
+
+// parseTodoPatch validates a partial update body and maps it to columns. Only
+// user-editable fields are accepted.
+func parseTodoPatch(body map[string]json.RawMessage) (map[string]interface{}, error) {
+    updates := make(map[string]interface{}, len(body))
+    for name, raw := range body {
+        switch name {
+        case "title":
+            var title string
+            if err := json.Unmarshal(raw, &title); err != nil || strings.TrimSpace(title) == "" {
+                return nil, fmt.Errorf("title must be a non-empty string")
+            }
+            updates["title"] = title
+        case "description":
+            var description string
+            if err := json.Unmarshal(raw, &description); err != nil {
+                return nil, fmt.Errorf("description must be a string")
+            }
+            updates["description"] = description
+        case "completed":
+            var completed bool
+            if err := json.Unmarshal(raw, &completed); err != nil {
+                return nil, fmt.Errorf("completed must be a boolean")
+            }
+            updates["completed"] = completed
+            updates["completed_at"] = completedAtUpdate(completed)
+        case "due_date":
+            var dueDate *time.Time
+            if err := json.Unmarshal(raw, &dueDate); err != nil {
+                return nil, fmt.Errorf("due_date must be an RFC 3339 timestamp or null")
+            }
+            updates["due_date"] = dueDate
+        default:
+            return nil, fmt.Errorf("field %q cannot be updated", name)
+        }
+    }
+    return updates, nil
+}
+
+// patchTodos applies the same field changes to every todo matching the list
+// filters. At least one filter is required so the whole table can't be
+// rewritten by accident.
+func (s *Server) patchTodos(w http.ResponseWriter, r *http.Request) {
+    if !hasTodoFilters(r) {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at least one filter is required (%s)", strings.Join(todoFilterParams, ", ")))
+        return
+    }
+    filters, err := s.parseTodoFilters(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    var body map[string]json.RawMessage
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(body) == 0 {
+        writeError(w, http.StatusBadRequest, "request body must contain at least one field to update")
+        return
+    }
+    updates, err := parseTodoPatch(body)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    // Map updates skip gorm serializers, so seal the description here
+    if description, ok := updates["description"].(string); ok {
+        if err := s.checkDescription(description); err != nil {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        if updates["description"], err = s.config.FieldCipher.seal(description); err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+    }
+
+    var updated int64
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        result := tx.Model(&Todo{}).Scopes(filters).Updates(updates)
+        updated = result.RowsAffected
+        return result.Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]int64{"updated": updated})
+}
+
+// batchGetTodos returns the todos for a list of UUIDs in one query, along with
+// the requested UUIDs that don't exist.
+func (s *Server) batchGetTodos(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        UUIDs []string `json:"uuids"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(body.UUIDs) == 0 {
+        writeError(w, http.StatusBadRequest, "uuids must not be empty")
+        return
+    }
+    if len(body.UUIDs) > maxBatchUUIDs {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d uuids can be fetched at once", maxBatchUUIDs))
+        return
+    }
+
+    todos := []Todo{}
+    err := s.withReadRetry(func() error {
+        return s.db.Preload("Tags").Where("uuid IN ?", body.UUIDs).Find(&todos).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    found := make(map[string]bool, len(todos))
+    for _, todo := range todos {
+        found[todo.UUID] = true
+    }
+    notFound := []string{}
+    for _, id := range body.UUIDs {
+        if !found[id] {
+            notFound = append(notFound, id)
+            found[id] = true
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "todos":     todos,
+        "not_found": notFound,
+    })
+}
+
+// Upper bound on the todos a single reorder can position
+const maxReorderUUIDs = 1000
+
+// reorderTodos sets the position of the given todos to their order in the
+// body. It runs serializably so two concurrent drag-reorders can't interleave
+// their writes and leave a mixed order behind.
+func (s *Server) reorderTodos(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        UUIDs []string `json:"uuids"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(body.UUIDs) == 0 {
+        writeError(w, http.StatusBadRequest, "uuids must not be empty")
+        return
+    }
+    if len(body.UUIDs) > maxReorderUUIDs {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d uuids can be reordered at once", maxReorderUUIDs))
+        return
+    }
+    seen := make(map[string]bool, len(body.UUIDs))
+    for _, id := range body.UUIDs {
+        if seen[id] {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("uuid %q is listed more than once", id))
+            return
+        }
+        seen[id] = true
+    }
+
+    var missing []string
+    err := s.withSerializableTx(func(tx *gorm.DB) error {
+        var existing []string
+        if err := tx.Model(&Todo{}).Where("uuid IN ?", body.UUIDs).Pluck("uuid", &existing).Error; err != nil {
+            return err
+        }
+        missing = nil
+        if len(existing) != len(body.UUIDs) {
+            found := make(map[string]bool, len(existing))
+            for _, id := range existing {
+                found[id] = true
+            }
+            for _, id := range body.UUIDs {
+                if !found[id] {
+                    missing = append(missing, id)
+                }
+            }
+            return nil
+        }
+
+        for i, id := range body.UUIDs {
+            if err := tx.Model(&Todo{}).Where("uuid = ?", id).UpdateColumn("position", i+1).Error; err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if len(missing) > 0 {
+        writeJSON(w, http.StatusNotFound, map[string]interface{}{
+            "error":     "some todos do not exist",
+            "not_found": missing,
+        })
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]int{"reordered": len(body.UUIDs)})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "crypto/subtle"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const icalTimeFormat = "20060102T150405Z"
+
+// icalEscape escapes a TEXT value per RFC 5545
+func icalEscape(value string) string {
+    return strings.NewReplacer(
+        `\`, `\\`,
+        ";", `\;`,
+        ",", `\,`,
+        "\r\n", `\n`,
+        "\n", `\n`,
+        "\r", `\n`,
+    ).Replace(value)
+}
+
+// icalLine folds a content line at 75 octets, continuing with a space, without
+// splitting UTF-8 sequences
+func icalLine(b *strings.Builder, line string) {
+    limit := 75
+    for len(line) > limit {
+        cut := limit
+        for cut > 0 && line[cut]&0xC0 == 0x80 {
+            cut--
+        }
+        b.WriteString(line[:cut])
+        b.WriteString("\r\n ")
+        line = line[cut:]
+        // Continuation lines count their leading space
+        limit = 74
+    }
+    b.WriteString(line)
+    b.WriteString("\r\n")
+}
+
+// todoCalendar renders todos as an iCalendar feed. Events are what Google
+// Calendar and most subscription clients display; VTODO suits task apps.
+func todoCalendar(todos []Todo, component string, now time.Time) string {
+    var b strings.Builder
+    icalLine(&b, "BEGIN:VCALENDAR")
+    icalLine(&b, "VERSION:2.0")
+    icalLine(&b, "PRODID:-//k8s-playground//todo//EN")
+    icalLine(&b, "CALSCALE:GREGORIAN")
+    icalLine(&b, "X-WR-CALNAME:Todos")
+    for _, todo := range todos {
+        due := todo.DueDate.UTC().Format(icalTimeFormat)
+        if component == "todo" {
+            icalLine(&b, "BEGIN:VTODO")
+        } else {
+            icalLine(&b, "BEGIN:VEVENT")
+        }
+        icalLine(&b, "UID:"+todo.UUID)
+        icalLine(&b, "DTSTAMP:"+now.UTC().Format(icalTimeFormat))
+        icalLine(&b, "LAST-MODIFIED:"+todo.UpdatedAt.UTC().Format(icalTimeFormat))
+        icalLine(&b, "SUMMARY:"+icalEscape(todo.Title))
+        if todo.Description != "" {
+            icalLine(&b, "DESCRIPTION:"+icalEscape(todo.Description))
+        }
+        if len(todo.Tags) > 0 {
+            names := make([]string, len(todo.Tags))
+            for i, tag := range todo.Tags {
+                names[i] = icalEscape(tag.Name)
+            }
+            icalLine(&b, "CATEGORIES:"+strings.Join(names, ","))
+        }
+        if component == "todo" {
+            icalLine(&b, "DUE:"+due)
+            icalLine(&b, "STATUS:NEEDS-ACTION")
+            icalLine(&b, "END:VTODO")
+        } else {
+            icalLine(&b, "DTSTART:"+due)
+            icalLine(&b, "DTEND:"+due)
+            icalLine(&b, "TRANSP:TRANSPARENT")
+            icalLine(&b, "END:VEVENT")
+        }
+    }
+    icalLine(&b, "END:VCALENDAR")
+    return b.String()
+}
+
+// getTodoCalendar serves incomplete todos with a due date as an iCalendar
+// feed. Calendar clients can't send headers, so it is authenticated with
+// ?token=CALENDAR_TOKEN and disabled while that is unset. ?component=todo
+// emits VTODO entries instead of events.
+func (s *Server) getTodoCalendar(w http.ResponseWriter, r *http.Request) {
+    if s.config.CalendarToken == "" {
+        writeError(w, http.StatusForbidden, "calendar feed is disabled, set CALENDAR_TOKEN to enable it")
+        return
+    }
+    token := r.URL.Query().Get("token")
+    if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.CalendarToken)) != 1 {
+        writeError(w, http.StatusUnauthorized, "invalid calendar token")
+        return
+    }
+
+    component := r.URL.Query().Get("component")
+    switch component {
+    case "":
+        component = "event"
+    case "event", "todo":
+    default:
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid component %q, must be event or todo", component))
+        return
+    }
+
+    todos := []Todo{}
+    err := s.withReadRetry(func() error {
+        return s.db.Preload("Tags").
+            Where("completed = ? AND archived = ? AND due_date IS NOT NULL", false, false).
+            Order("due_date, id").
+            Find(&todos).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+    w.Header().Set("Content-Disposition", "inline; filename=todos.ics")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, todoCalendar(todos, component, time.Now()))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+)
+
+type contextKey string
+
+const clientIPKey contextKey = "clientIP"
+
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+    var networks []*net.IPNet
+    for _, entry := range strings.Split(value, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+
+        // Accept bare addresses as single-host ranges
+        if !strings.Contains(entry, "/") {
+            ip := net.ParseIP(entry)
+            if ip == nil {
+                return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+            }
+            if ip.To4() != nil {
+                entry += "/32"
+            } else {
+                entry += "/128"
+            }
+        }
+
+        _, network, err := net.ParseCIDR(entry)
+        if err != nil {
+            return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+        }
+        networks = append(networks, network)
+    }
+    return networks, nil
+}
+
+func isTrustedProxy(trusted []*net.IPNet, ip net.IP) bool {
+    for _, network := range trusted {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// resolveClientIP only honours X-Forwarded-For / X-Real-IP when the immediate
+// peer is a trusted proxy, otherwise anyone could spoof their address.
+func resolveClientIP(trusted []*net.IPNet, r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+
+    peer := net.ParseIP(host)
+    if peer == nil || !isTrustedProxy(trusted, peer) {
+        return host
+    }
+
+    var hops []string
+    for _, value := range r.Header.Values("X-Forwarded-For") {
+        hops = append(hops, strings.Split(value, ",")...)
+    }
+
+    // Walk right to left, the first untrusted hop is the real client
+    client := ""
+    for i := len(hops) - 1; i >= 0; i-- {
+        ip := net.ParseIP(strings.TrimSpace(hops[i]))
+        if ip == nil {
+            break
+        }
+        client = ip.String()
+        if !isTrustedProxy(trusted, ip) {
+            return client
+        }
+    }
+    if client != "" {
+        return client
+    }
+
+    if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+        return ip.String()
+    }
+    return host
+}
+
+func (s *Server) withClientIP(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx := context.WithValue(r.Context(), clientIPKey, resolveClientIP(s.config.TrustedProxies, r))
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+func clientIP(r *http.Request) string {
+    if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+        return ip
+    }
+    // Outside withClientIP no proxy is trusted, so this is just the peer
+    return resolveClientIP(nil, r)
+}
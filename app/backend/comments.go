@@ -0,0 +1,90 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Longest comment body accepted, in bytes
+const maxCommentLength = 10000
+
+// Comment is a timestamped note appended to a todo
+type Comment struct {
+    ID        jsonID    `json:"id" gorm:"primarykey"`
+    TodoUUID  string    `json:"todo_uuid" gorm:"index;not null"`
+    Body      string    `json:"body" gorm:"not null"`
+    Author    string    `json:"author"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// todoExists answers 404/500 itself when the todo can't be used
+func (s *Server) todoExists(w http.ResponseWriter, uuid string) bool {
+    var todo Todo
+    err := s.db.Select("id").Where("uuid = ?", uuid).First(&todo).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "todo not found")
+        return false
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return false
+    }
+    return true
+}
+
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request) {
+    todoUUID := mux.Vars(r)["uuid"]
+
+    var body struct {
+        Body   string `json:"body"`
+        Author string `json:"author"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    body.Body = strings.TrimSpace(body.Body)
+    if body.Body == "" {
+        writeError(w, http.StatusBadRequest, "body is required")
+        return
+    }
+    if len(body.Body) > maxCommentLength {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("body must be at most %d bytes", maxCommentLength))
+        return
+    }
+
+    if !s.todoExists(w, todoUUID) {
+        return
+    }
+
+    comment := Comment{TodoUUID: todoUUID, Body: body.Body, Author: strings.TrimSpace(body.Author)}
+    if err := s.db.Create(&comment).Error; err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusCreated, comment)
+}
+
+// listComments returns a todo's comments, oldest first
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request) {
+    todoUUID := mux.Vars(r)["uuid"]
+    if !s.todoExists(w, todoUUID) {
+        return
+    }
+
+    comments := []Comment{}
+    err := s.withReadRetry(func() error {
+        return s.db.Where("todo_uuid = ?", todoUUID).Order("created_at, id").Find(&comments).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, comments)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "log"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// envBool reads a boolean env var, exiting on values strconv can't parse
+func envBool(name string, fallback bool) bool {
+    value := os.Getenv(name)
+    if value == "" {
+        return fallback
+    }
+    parsed, err := strconv.ParseBool(value)
+    if err != nil {
+        log.Fatalf("Invalid %s: %q", name, value)
+    }
+    return parsed
+}
+
+// envInt64 reads a non-negative integer env var, exiting on invalid values
+func envInt64(name string, fallback int64) int64 {
+    value := os.Getenv(name)
+    if value == "" {
+        return fallback
+    }
+    parsed, err := strconv.ParseInt(value, 10, 64)
+    if err != nil || parsed < 0 {
+        log.Fatalf("Invalid %s: %q", name, value)
+    }
+    return parsed
+}
+
+// envFloat reads a non-negative decimal env var, exiting on invalid values
+func envFloat(name string, fallback float64) float64 {
+    value := os.Getenv(name)
+    if value == "" {
+        return fallback
+    }
+    parsed, err := strconv.ParseFloat(value, 64)
+    if err != nil || parsed < 0 {
+        log.Fatalf("Invalid %s: %q", name, value)
+    }
+    return parsed
+}
+
+// envList reads a comma separated env var, ignoring blank entries
+func envList(name string, fallback []string) []string {
+    value := os.Getenv(name)
+    if value == "" {
+        return fallback
+    }
+    var items []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "fmt"
+    "mime"
+    "net/http"
+    "strings"
+)
+
+// isJSONMediaType accepts application/json and structured +json types such as
+// application/vnd.api+json, ignoring parameters like charset
+func isJSONMediaType(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return false
+    }
+    return mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// requireJSON answers 415 for write requests whose body isn't declared as
+// JSON, so a form-encoded body gets a clear error instead of a parse failure.
+// Requests without a body pass through for endpoints where it is optional.
+func requireJSON(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.ContentLength == 0 {
+            next(w, r)
+            return
+        }
+        if contentType := r.Header.Get("Content-Type"); !isJSONMediaType(contentType) {
+            if contentType == "" {
+                contentType = "none"
+            }
+            w.Header().Set("Accept", "application/json")
+            writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Content-Type must be application/json, got %s", contentType))
+            return
+        }
+        next(w, r)
+    }
+}
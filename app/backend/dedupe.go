@@ -0,0 +1,59 @@
+package main
+
+import (
+    "log"
+    "time"
+)
+
+// Concurrent requests for the same file share one metadata lookup through
+// s.lookups instead of each hitting storage or the database. Keys are
+// prefixed by the kind of lookup. Off with DEDUPE_FILE_LOOKUPS=false.
+
+// statFile is storage.Stat, shared between concurrent callers for a name
+func (s *Server) statFile(name string) (FileInfo, error) {
+    if !s.config.DedupeFileLookups {
+        return s.storage.Stat(name)
+    }
+    value, err, _ := s.lookups.Do("stat:"+name, func() (interface{}, error) {
+        return s.storage.Stat(name)
+    })
+    if err != nil {
+        return FileInfo{}, err
+    }
+    return value.(FileInfo), nil
+}
+
+// fileByHash finds the oldest upload with the given SHA-256
+func (s *Server) fileByHash(sum string) (File, error) {
+    lookup := func() (interface{}, error) {
+        var record File
+        err := s.db.Where("sha256 = ?", sum).Order("id").First(&record).Error
+        return record, err
+    }
+    if !s.config.DedupeFileLookups {
+        value, err := lookup()
+        return value.(File), err
+    }
+    value, err, _ := s.lookups.Do("hash:"+sum, lookup)
+    if err != nil {
+        return File{}, err
+    }
+    return value.(File), nil
+}
+
+// touchFile records a download for LRU-style cleanup. A burst of downloads
+// of the same file results in a single UPDATE.
+func (s *Server) touchFile(name string) {
+    touch := func() (interface{}, error) {
+        err := s.db.Model(&File{}).Where("name = ?", name).UpdateColumn("last_accessed", time.Now()).Error
+        if err != nil {
+            log.Printf("Failed to record access to %s: %v", name, err)
+        }
+        return nil, err
+    }
+    if !s.config.DedupeFileLookups {
+        touch()
+        return
+    }
+    s.lookups.Do("touch:"+name, touch)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "encoding/base64"
+    "fmt"
+    "log"
+
+    "gorm.io/gorm"
+)
+
+const descriptionConstraint = "chk_todos_description_size"
+
+// checkDescription applies MAX_DESCRIPTION_BYTES before anything is written
+func (s *Server) checkDescription(description string) error {
+    if int64(len(description)) > s.config.MaxDescriptionBytes {
+        return fmt.Errorf("description must be at most %d bytes", s.config.MaxDescriptionBytes)
+    }
+    return nil
+}
+
+// sealedDescriptionLimit is the stored size of a maximum-length description
+// once FIELD_ENCRYPTION_KEY has sealed it: prefix, nonce and GCM tag, base64
+func sealedDescriptionLimit(limit int64) int64 {
+    const nonceSize, tagSize = 12, 16
+    return int64(len(encryptedPrefix) + base64.StdEncoding.EncodedLen(nonceSize+int(limit)+tagSize))
+}
+
+// enforceDescriptionLimit (re)creates a CHECK constraint so the limit also
+// holds for writers that bypass the API. Sealed values get the matching
+// larger bound. The constraint is NOT VALID: existing rows are left alone,
+// new and updated rows are checked.
+func enforceDescriptionLimit(db *gorm.DB, limit int64) error {
+    check := fmt.Sprintf(
+        "octet_length(description) <= %d OR (description LIKE '%s%%' AND octet_length(description) <= %d)",
+        limit, encryptedPrefix, sealedDescriptionLimit(limit),
+    )
+    return db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Exec("ALTER TABLE todos DROP CONSTRAINT IF EXISTS " + descriptionConstraint).Error; err != nil {
+            return err
+        }
+        err := tx.Exec("ALTER TABLE todos ADD CONSTRAINT " + descriptionConstraint + " CHECK (" + check + ") NOT VALID").Error
+        if err != nil {
+            return err
+        }
+        var oversized int64
+        if err := tx.Raw("SELECT COUNT(*) FROM todos WHERE NOT (" + check + ")").Scan(&oversized).Error; err != nil {
+            return err
+        }
+        if oversized > 0 {
+            log.Printf("Warning: %d todos have descriptions over MAX_DESCRIPTION_BYTES (%d), they can't be updated until shortened", oversized, limit)
+        }
+        return nil
+    })
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// Draft holds unsaved title/description edits for a todo. Auto-saves land
+// here so the todo itself, and its updated_at, only change on commit.
+type Draft struct {
+    TodoUUID    string    `json:"todo_uuid" gorm:"primarykey"`
+    Title       string    `json:"title"`
+    Description string    `json:"description" gorm:"serializer:encrypted"`
+    UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// saveDraft merges title and/or description into the todo's draft, starting
+// from the todo's current values the first time
+func (s *Server) saveDraft(w http.ResponseWriter, r *http.Request) {
+    todoUUID := mux.Vars(r)["uuid"]
+
+    var body struct {
+        Title       *string `json:"title"`
+        Description *string `json:"description"`
+    }
+    if err := decodeJSONStrict(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if body.Title == nil && body.Description == nil {
+        writeError(w, http.StatusBadRequest, "title or description is required")
+        return
+    }
+    if body.Description != nil {
+        if err := s.checkDescription(*body.Description); err != nil {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+    }
+
+    var draft Draft
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        err := tx.Where("todo_uuid = ?", todoUUID).First(&draft).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            var todo Todo
+            if err := tx.Select("title", "description").Where("uuid = ?", todoUUID).First(&todo).Error; err != nil {
+                return err
+            }
+            draft = Draft{TodoUUID: todoUUID, Title: todo.Title, Description: todo.Description}
+        } else if err != nil {
+            return err
+        }
+
+        if body.Title != nil {
+            draft.Title = *body.Title
+        }
+        if body.Description != nil {
+            draft.Description = *body.Description
+        }
+        return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&draft).Error
+    })
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "todo not found")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, draft)
+}
+
+func (s *Server) getDraft(w http.ResponseWriter, r *http.Request) {
+    var draft Draft
+    err := s.db.Where("todo_uuid = ?", mux.Vars(r)["uuid"]).First(&draft).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "no draft for this todo")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, draft)
+}
+
+func (s *Server) discardDraft(w http.ResponseWriter, r *http.Request) {
+    if err := s.db.Where("todo_uuid = ?", mux.Vars(r)["uuid"]).Delete(&Draft{}).Error; err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// commitDraft writes the draft onto the todo as a regular update and drops it
+func (s *Server) commitDraft(w http.ResponseWriter, r *http.Request) {
+    todoUUID := mux.Vars(r)["uuid"]
+
+    var todo Todo
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var draft Draft
+        if err := tx.Where("todo_uuid = ?", todoUUID).First(&draft).Error; err != nil {
+            return err
+        }
+        if err := tx.Preload("Tags").Where("uuid = ?", todoUUID).First(&todo).Error; err != nil {
+            return err
+        }
+
+        todo.Title, todo.Description = draft.Title, draft.Description
+        // Select so an emptied description is written too
+        if err := tx.Model(&todo).Select("title", "description").Updates(&todo).Error; err != nil {
+            return err
+        }
+        return tx.Delete(&draft).Error
+    })
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "no draft for this todo")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, todo)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+
+    "gorm.io/gorm/schema"
+)
+
+// Marks a column value as sealed by fieldCipher, so plaintext written before
+// encryption was enabled still reads back
+const encryptedPrefix = "enc:v1:"
+
+// fieldCipher seals individual column values with AES-GCM
+type fieldCipher struct {
+    aead cipher.AEAD
+}
+
+// newFieldCipher takes a base64 encoded 16, 24 or 32 byte AES key. An empty
+// key disables encryption and returns nil.
+func newFieldCipher(encodedKey string) (*fieldCipher, error) {
+    if encodedKey == "" {
+        return nil, nil
+    }
+    key, err := base64.StdEncoding.DecodeString(encodedKey)
+    if err != nil {
+        return nil, errors.New("key must be base64 encoded")
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("key must decode to 16, 24 or 32 bytes, got %d", len(key))
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return &fieldCipher{aead: aead}, nil
+}
+
+func (c *fieldCipher) seal(plaintext string) (string, error) {
+    if c == nil {
+        return plaintext, nil
+    }
+    nonce := make([]byte, c.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+    return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *fieldCipher) open(value string) (string, error) {
+    if !strings.HasPrefix(value, encryptedPrefix) {
+        return value, nil
+    }
+    if c == nil {
+        return "", errors.New("value is encrypted but FIELD_ENCRYPTION_KEY is not set")
+    }
+    sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+    if err != nil || len(sealed) < c.aead.NonceSize() {
+        return "", errors.New("malformed encrypted value")
+    }
+    nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+    plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+    if err != nil {
+        return "", errors.New("failed to decrypt value, wrong FIELD_ENCRYPTION_KEY?")
+    }
+    return string(plaintext), nil
+}
+
+// encryptedSerializer backs gorm:"serializer:encrypted" string fields. Note
+// that map based updates bypass serializers and must seal values themselves.
+type encryptedSerializer struct {
+    cipher *fieldCipher
+}
+
+func (e encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+    var value string
+    switch v := dbValue.(type) {
+    case nil:
+    case string:
+        value = v
+    case []byte:
+        value = string(v)
+    default:
+        return fmt.Errorf("unsupported value %T for encrypted field %s", dbValue, field.Name)
+    }
+    plaintext, err := e.cipher.open(value)
+    if err != nil {
+        return fmt.Errorf("%s: %v", field.Name, err)
+    }
+    return field.Set(ctx, dst, plaintext)
+}
+
+func (e encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+    plaintext, _ := fieldValue.(string)
+    return e.cipher.seal(plaintext)
+}
+
+// encryptedJSONSerializer backs gorm:"serializer:encryptedjson" fields: the
+// value is stored as JSON like serializer:json, sealed as a whole. Used where
+// descriptions are copied into a JSON column, such as template items.
+type encryptedJSONSerializer struct {
+    cipher *fieldCipher
+}
+
+func (e encryptedJSONSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+    var value string
+    switch v := dbValue.(type) {
+    case nil:
+        return nil
+    case string:
+        value = v
+    case []byte:
+        value = string(v)
+    default:
+        return fmt.Errorf("unsupported value %T for encrypted field %s", dbValue, field.Name)
+    }
+    plaintext, err := e.cipher.open(value)
+    if err != nil {
+        return fmt.Errorf("%s: %v", field.Name, err)
+    }
+    decoded := reflect.New(field.FieldType)
+    if err := json.Unmarshal([]byte(plaintext), decoded.Interface()); err != nil {
+        return fmt.Errorf("%s: %v", field.Name, err)
+    }
+    return field.Set(ctx, dst, decoded.Elem().Interface())
+}
+
+func (e encryptedJSONSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+    encoded, err := json.Marshal(fieldValue)
+    if err != nil {
+        return nil, err
+    }
+    return e.cipher.seal(string(encoded))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+// todoListETag is a weak ETag over a page of todos as loaded for the
+// response. Every row contributes its id, updated_at, position, overdue flag
+// and sorted tags, since reordering and tagging don't touch updated_at. The
+// total, query string and Accept header are part of it too, as they shape
+// the response.
+func todoListETag(r *http.Request, todos []Todo, total int64) string {
+    hash := sha256.New()
+    fmt.Fprintf(hash, "%d|%s|%s\n", total, r.URL.RawQuery, r.Header.Get("Accept"))
+    for _, todo := range todos {
+        tags := make([]string, len(todo.Tags))
+        for i, tag := range todo.Tags {
+            tags[i] = fmt.Sprintf("%d:%s", tag.ID, tag.Name)
+        }
+        sort.Strings(tags)
+        fmt.Fprintf(hash, "%d|%d|%d|%t|%s\n",
+            todo.ID, todo.UpdatedAt.UnixNano(), todo.Position, todo.IsOverdue, strings.Join(tags, ","))
+    }
+    return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches applies If-None-Match's weak comparison: a list of tags, or *
+func etagMatches(header, etag string) bool {
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+    "compress/gzip"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// Rows buffered between tag lookups and flushes while streaming an export
+const exportChunkSize = 500
+
+// acceptsGzip reports whether Accept-Encoding allows gzip with a non-zero q
+func acceptsGzip(r *http.Request) bool {
+    for _, value := range r.Header.Values("Accept-Encoding") {
+        for _, part := range strings.Split(value, ",") {
+            coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+            coding = strings.ToLower(strings.TrimSpace(coding))
+            if coding != "gzip" && coding != "*" {
+                continue
+            }
+            q := 1.0
+            if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+                if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+                    q = parsed
+                }
+            }
+            if q > 0 {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// exportTodos streams every todo matching the list filters straight from a
+// database cursor, so memory use stays flat however large the table is.
+// format=json (default) writes a JSON array, format=jsonl one object per line.
+// The output is gzipped when the client accepts it, and capped at
+// EXPORT_MAX_ROWS with a 206 when there are more matching todos.
+func (s *Server) exportTodos(w http.ResponseWriter, r *http.Request) {
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "json"
+    }
+    if format != "json" && format != "jsonl" {
+        writeError(w, http.StatusBadRequest, "format must be json or jsonl")
+        return
+    }
+
+    filters, err := s.parseTodoFilters(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    // Past EXPORT_MAX_ROWS only the first rows are sent, flagged with a 206
+    status := http.StatusOK
+    query := s.db.Model(&Todo{}).Scopes(filters).Order("id")
+    if s.config.ExportMaxRows > 0 {
+        var total int64
+        if err := s.db.Model(&Todo{}).Scopes(filters).Count(&total).Error; err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        if total > s.config.ExportMaxRows {
+            status = http.StatusPartialContent
+            query = query.Limit(int(s.config.ExportMaxRows))
+            w.Header().Set("X-Export-Truncated", "true")
+            w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+            w.Header().Set("X-Export-Rows", strconv.FormatInt(s.config.ExportMaxRows, 10))
+        }
+    }
+
+    rows, err := query.Rows()
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    defer rows.Close()
+
+    if format == "jsonl" {
+        w.Header().Set("Content-Type", "application/x-ndjson")
+    } else {
+        w.Header().Set("Content-Type", "application/json")
+    }
+    fileName := "todos." + format
+
+    var out io.Writer = w
+    flusher, _ := w.(http.Flusher)
+    flush := func() {
+        if flusher != nil {
+            flusher.Flush()
+        }
+    }
+    if acceptsGzip(r) {
+        // Level is validated at startup, so this can't fail
+        gz, _ := gzip.NewWriterLevel(w, s.config.GzipLevel)
+        defer gz.Close()
+        out = gz
+        flush = func() {
+            gz.Flush()
+            if flusher != nil {
+                flusher.Flush()
+            }
+        }
+        fileName += ".gz"
+        w.Header().Set("Content-Encoding", "gzip")
+    }
+    w.Header().Add("Vary", "Accept-Encoding")
+    w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+    w.WriteHeader(status)
+
+    encoder := json.NewEncoder(out)
+    written := 0
+    chunk := make([]Todo, 0, exportChunkSize)
+
+    writeChunk := func() error {
+        if err := loadTags(s.db, chunk); err != nil {
+            return err
+        }
+        for _, todo := range chunk {
+            if format == "json" {
+                separator := ","
+                if written == 0 {
+                    separator = "["
+                }
+                if _, err := io.WriteString(out, separator); err != nil {
+                    return err
+                }
+            }
+            if err := encoder.Encode(todo); err != nil {
+                return err
+            }
+            written++
+        }
+        chunk = chunk[:0]
+        flush()
+        return nil
+    }
+
+    for rows.Next() {
+        var todo Todo
+        if err := s.db.ScanRows(rows, &todo); err != nil {
+            log.Printf("Export aborted after %d todos: %v", written, err)
+            return
+        }
+        todo.setDerivedFields()
+        chunk = append(chunk, todo)
+        if len(chunk) == exportChunkSize {
+            if err := writeChunk(); err != nil {
+                log.Printf("Export aborted after %d todos: %v", written, err)
+                return
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        log.Printf("Export aborted after %d todos: %v", written, err)
+        return
+    }
+    if err := writeChunk(); err != nil {
+        log.Printf("Export aborted after %d todos: %v", written, err)
+        return
+    }
+
+    if format == "json" {
+        if written == 0 {
+            io.WriteString(out, "[")
+        }
+        io.WriteString(out, "]\n")
+    }
+}
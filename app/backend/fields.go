@@ -0,0 +1,80 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "reflect"
+    "strings"
+)
+
+// Names todos serialise under, used to validate ?fields=
+var todoFieldNames = jsonFieldNames(reflect.TypeOf(Todo{}))
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+    names := map[string]bool{}
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        tag := field.Tag.Get("json")
+        if tag == "-" || !field.IsExported() {
+            continue
+        }
+        name := strings.Split(tag, ",")[0]
+        if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+            for embedded := range jsonFieldNames(field.Type) {
+                names[embedded] = true
+            }
+            continue
+        }
+        if name == "" {
+            name = field.Name
+        }
+        names[name] = true
+    }
+    return names
+}
+
+// parseFields reads ?fields=uuid,title; nil means the full representation
+func parseFields(r *http.Request) ([]string, error) {
+    value := r.URL.Query().Get("fields")
+    if value == "" {
+        return nil, nil
+    }
+
+    var fields []string
+    for _, name := range strings.Split(value, ",") {
+        name = strings.TrimSpace(name)
+        if name == "" {
+            continue
+        }
+        if !todoFieldNames[name] {
+            return nil, fmt.Errorf("unknown field %q", name)
+        }
+        fields = append(fields, name)
+    }
+    return fields, nil
+}
+
+// selectFields projects a todo down to the requested JSON fields, nil fields
+// keeps them all
+func selectFields(todo Todo, fields []string) (map[string]json.RawMessage, error) {
+    data, err := json.Marshal(todo)
+    if err != nil {
+        return nil, err
+    }
+    var all map[string]json.RawMessage
+    if err := json.Unmarshal(data, &all); err != nil {
+        return nil, err
+    }
+    if fields == nil {
+        return all, nil
+    }
+
+    selected := make(map[string]json.RawMessage, len(fields))
+    for _, name := range fields {
+        if value, ok := all[name]; ok {
+            selected[name] = value
+        }
+    }
+    return selected, nil
+}
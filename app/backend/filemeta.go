@@ -0,0 +1,264 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "path/filepath"
+    "time"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// File is the metadata recorded for each upload. It is attached to todos
+// through the file_attachments table: a todo can have any number of
+// attachments, and with hash naming one file can be attached to several todos.
+type File struct {
+    ID          uint      `json:"-" gorm:"primarykey"`
+    CreatedAt   time.Time `json:"created_at"`
+    UpdatedAt   time.Time `json:"-"`
+    Name        string    `json:"name" gorm:"uniqueIndex"`
+    Size        int64     `json:"size"`
+    ContentType string    `json:"content_type"`
+    SHA256      string    `json:"sha256,omitempty" gorm:"column:sha256;index"`
+    // Logical folder, empty for the top level
+    Folder      string    `json:"folder,omitempty" gorm:"index;not null;default:''"`
+    // Last download, nil if never downloaded
+    LastAccessed *time.Time `json:"last_accessed,omitempty"`
+}
+
+// attachFile links a recorded file to a todo, doing nothing if it already is
+func attachFile(tx *gorm.DB, todoID jsonID, fileID uint) error {
+    return tx.Table("file_attachments").
+        Clauses(clause.OnConflict{DoNothing: true}).
+        Create(map[string]interface{}{"todo_id": todoID, "file_id": fileID}).Error
+}
+
+// deleteFileRecord drops a file's metadata along with its attachment links
+func deleteFileRecord(tx *gorm.DB, name string) error {
+    ids := tx.Session(&gorm.Session{NewDB: true}).Model(&File{}).Select("id").Where("name = ?", name)
+    if err := tx.Exec("DELETE FROM file_attachments WHERE file_id IN (?)", ids).Error; err != nil {
+        return err
+    }
+    return tx.Where("name = ?", name).Delete(&File{}).Error
+}
+
+// fileUsers lists the live todos using a stored file, as file_path or as an
+// attachment
+func (s *Server) fileUsers(tx *gorm.DB, name string) ([]Todo, error) {
+    attached := tx.Session(&gorm.Session{NewDB: true}).
+        Table("file_attachments").
+        Select("file_attachments.todo_id").
+        Joins("JOIN files ON files.id = file_attachments.file_id").
+        Where("files.name = ?", name)
+    var todos []Todo
+    err := tx.Select("id", "uuid", "file_path").
+        Where("file_path = ? OR id IN (?)", s.storage.Location(name), attached).
+        Order("id").
+        Find(&todos).Error
+    return todos, err
+}
+
+// migrateFileAttachments moves the links from the old files.todo_id column,
+// which allowed a file only one todo, into file_attachments
+func migrateFileAttachments(db *gorm.DB) error {
+    if !db.Migrator().HasColumn("files", "todo_id") {
+        return nil
+    }
+    return db.Transaction(func(tx *gorm.DB) error {
+        err := tx.Exec("INSERT INTO file_attachments (todo_id, file_id) SELECT todo_id, id FROM files WHERE todo_id IS NOT NULL ON CONFLICT DO NOTHING").Error
+        if err != nil {
+            return err
+        }
+        if tx.Migrator().HasColumn("trashed_files", "todo_id") {
+            err := tx.Exec("UPDATE trashed_files SET todo_ids = json_build_array(todo_id)::text WHERE todo_id IS NOT NULL").Error
+            if err != nil {
+                return err
+            }
+            if err := tx.Migrator().DropColumn("trashed_files", "todo_id"); err != nil {
+                return err
+            }
+        }
+        return tx.Migrator().DropColumn("files", "todo_id")
+    })
+}
+
+// saveHashed stores content under name and returns its hex SHA-256
+func (s *Server) saveHashed(name string, content io.Reader, size int64) (string, error) {
+    hash := sha256.New()
+    if err := s.storage.Save(name, io.TeeReader(content, hash), size); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// withLastAccessed fills in LastAccessed from the metadata table. Files that
+// were never downloaded, or predate the metadata, count from their mtime.
+func (s *Server) withLastAccessed(files []FileInfo) error {
+    var records []File
+    if err := s.db.Select("name", "last_accessed").Where("last_accessed IS NOT NULL").Find(&records).Error; err != nil {
+        return err
+    }
+    accessed := make(map[string]time.Time, len(records))
+    for _, record := range records {
+        accessed[record.Name] = *record.LastAccessed
+    }
+
+    for i := range files {
+        files[i].LastAccessed = files[i].ModTime
+        if at, ok := accessed[files[i].Name]; ok && at.After(files[i].ModTime) {
+            files[i].LastAccessed = at
+        }
+    }
+    return nil
+}
+
+// detectContentType goes by extension first and falls back to sniffing the
+// content, leaving the reader rewound.
+func detectContentType(name string, content io.ReadSeeker) string {
+    if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+        return contentType
+    }
+
+    buf := make([]byte, 512)
+    n, _ := io.ReadFull(content, buf)
+    content.Seek(0, io.SeekStart)
+    return http.DetectContentType(buf[:n])
+}
+
+// Upper bound on the names accepted by POST /files/metadata
+const maxBatchFileNames = 100
+
+// batchFileMetadata returns the metadata records for a list of file names in
+// one query, along with the requested names that have none
+func (s *Server) batchFileMetadata(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        Names []string `json:"names"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(body.Names) == 0 {
+        writeError(w, http.StatusBadRequest, "names must not be empty")
+        return
+    }
+    if len(body.Names) > maxBatchFileNames {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d names can be fetched at once", maxBatchFileNames))
+        return
+    }
+
+    files := []File{}
+    err := s.withReadRetry(func() error {
+        return s.db.Where("name IN ?", body.Names).Order("name").Find(&files).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    found := make(map[string]bool, len(files))
+    for _, file := range files {
+        found[file.Name] = true
+    }
+    notFound := []string{}
+    for _, name := range body.Names {
+        if !found[name] {
+            notFound = append(notFound, name)
+            found[name] = true
+        }
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "files":     files,
+        "not_found": notFound,
+    })
+}
+
+const (
+    defaultLargestFiles = 10
+    maxLargestFiles     = 100
+)
+
+type largestFile struct {
+    File
+    // UUIDs of the todos using the file as file_path or attachment
+    Todos []string `json:"todos"`
+}
+
+// getLargestFiles lists the ?limit= biggest uploads from the metadata table,
+// so it doesn't have to stat every stored file, with the todos using each
+func (s *Server) getLargestFiles(w http.ResponseWriter, r *http.Request) {
+    limit, err := parseLimit(r, defaultLargestFiles, maxLargestFiles)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    var files []File
+    err = s.withReadRetry(func() error {
+        return s.db.Order("size DESC, name").Limit(limit).Find(&files).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    largest := make([]largestFile, len(files))
+    byLocation := make(map[string]int, len(files))
+    byFileID := make(map[uint]int, len(files))
+    locations := make([]string, len(files))
+    fileIDs := make([]uint, len(files))
+    for i, file := range files {
+        largest[i] = largestFile{File: file, Todos: []string{}}
+        locations[i] = s.storage.Location(file.Name)
+        byLocation[locations[i]] = i
+        byFileID[file.ID] = i
+        fileIDs[i] = file.ID
+    }
+
+    if len(files) > 0 {
+        var links []struct {
+            TodoID jsonID
+            FileID uint
+        }
+        if err := s.db.Table("file_attachments").Where("file_id IN ?", fileIDs).Find(&links).Error; err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        byTodoID := map[jsonID][]int{}
+        var todoIDs []jsonID
+        for _, link := range links {
+            byTodoID[link.TodoID] = append(byTodoID[link.TodoID], byFileID[link.FileID])
+            todoIDs = append(todoIDs, link.TodoID)
+        }
+
+        var todos []Todo
+        query := s.db.Select("id", "uuid", "file_path").Where("file_path IN ?", locations)
+        if len(todoIDs) > 0 {
+            query = query.Or("id IN ?", todoIDs)
+        }
+        if err := query.Order("id").Find(&todos).Error; err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        for _, todo := range todos {
+            seen := map[int]bool{}
+            if i, ok := byLocation[todo.FilePath]; ok {
+                largest[i].Todos = append(largest[i].Todos, todo.UUID)
+                seen[i] = true
+            }
+            for _, i := range byTodoID[todo.ID] {
+                if !seen[i] {
+                    largest[i].Todos = append(largest[i].Todos, todo.UUID)
+                }
+            }
+        }
+    }
+
+    writeJSON(w, http.StatusOK, largest)
+}
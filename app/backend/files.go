@@ -0,0 +1,597 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "log"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// storageUsage sums the size of every stored upload, trashed ones included,
+// from the metadata tables rather than listing the whole storage backend.
+// Files uploaded before metadata was tracked are not counted.
+func (s *Server) storageUsage() (int64, error) {
+    var total int64
+    err := s.db.Raw("SELECT COALESCE((SELECT SUM(size) FROM files), 0) + COALESCE((SELECT SUM(size) FROM trashed_files), 0)").
+        Scan(&total).Error
+    return total, err
+}
+
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
+    // Parts beyond MULTIPART_MAX_MEMORY spill to temp files on disk
+    if err := r.ParseMultipartForm(s.config.MultipartMaxMemory); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    file, header, err := r.FormFile("file")
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    defer file.Close()
+
+    // Optionally attach the upload to a todo, ?todo=<uuid> or a todo form field
+    var todoID *jsonID
+    if todoUUID := r.FormValue("todo"); todoUUID != "" {
+        var todo Todo
+        err := s.db.Select("id").Where("uuid = ?", todoUUID).First(&todo).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            writeError(w, http.StatusNotFound, "todo not found")
+            return
+        }
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        todoID = &todo.ID
+    }
+
+    // ?overwrite=<stored name> replaces that file instead of adding a new one
+    overwrite := r.URL.Query().Get("overwrite")
+    var replacedSize int64
+    if overwrite != "" {
+        if overwrite, err = sanitizeFileName(overwrite); err != nil {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        // A hash name stands for its content, upload the new content instead
+        if isHashName(overwrite) {
+            writeError(w, http.StatusConflict, "hash-named files can't be overwritten, upload the new content as a new file")
+            return
+        }
+        info, err := s.storage.Stat(overwrite)
+        if errors.Is(err, fs.ErrNotExist) {
+            writeError(w, http.StatusNotFound, "File not found")
+            return
+        }
+        if err != nil {
+            writeStorageError(w, err)
+            return
+        }
+        replacedSize = info.Size
+    }
+
+    if s.config.MaxUploadDirBytes > 0 {
+        used, err := s.storageUsage()
+        if err != nil {
+            writeStorageError(w, err)
+            return
+        }
+        if used-replacedSize+header.Size > s.config.MaxUploadDirBytes {
+            writeQuotaExceeded(w, http.StatusInsufficientStorage, "storage", s.config.MaxUploadDirBytes, used, nil,
+                fmt.Sprintf("upload would exceed the storage limit (%d of %d bytes used)", used, s.config.MaxUploadDirBytes))
+            return
+        }
+    }
+    if !s.checkOwnerQuota(w, todoID, overwrite, header.Size) {
+        return
+    }
+
+    if overwrite != "" {
+        s.replaceFile(w, overwrite, file, header.Size, todoID)
+        return
+    }
+
+    record, reused, err := s.storeUpload(header.Filename, file, header.Size)
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    s.recordUpload(w, record, todoID, reused)
+}
+
+// recordUpload saves the metadata for a newly stored upload, attaches it to
+// todoID if set, and answers with its location, deleting the stored file
+// again if that fails
+func (s *Server) recordUpload(w http.ResponseWriter, record File, todoID *jsonID, reused bool) {
+    fileName := record.Name
+
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        // With hash naming identical content is already stored and recorded,
+        // and the new todo is attached alongside the ones already using it
+        if err := tx.Where(File{Name: fileName}).FirstOrCreate(&record).Error; err != nil {
+            return err
+        }
+        if todoID == nil {
+            return nil
+        }
+        return attachFile(tx, *todoID, record.ID)
+    })
+    if err != nil {
+        if !reused {
+            s.storage.Delete(fileName)
+        }
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    response := map[string]string{"file_path": s.storage.Location(fileName)}
+    if s.generateThumbnail(fileName) {
+        response["thumbnail"] = fileName
+    }
+
+    writeJSON(w, http.StatusCreated, response)
+}
+
+// replaceFile writes the new content to a temporary name and renames it over
+// the existing file, so readers never see a partial upload. The file keeps its
+// name and its metadata is updated in place.
+func (s *Server) replaceFile(w http.ResponseWriter, fileName string, content io.ReadSeeker, size int64, todoID *jsonID) {
+    contentType := detectContentType(fileName, content)
+    tempName := fmt.Sprintf(".upload-%d-%s", time.Now().UnixNano(), fileName)
+    sum, err := s.saveHashed(tempName, content, size)
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    if err := s.storage.Rename(tempName, fileName); err != nil {
+        s.storage.Delete(tempName)
+        writeStorageError(w, err)
+        return
+    }
+
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        // Files uploaded before metadata was tracked get a row here
+        record := File{Name: fileName}
+        if err := tx.Where(File{Name: fileName}).FirstOrCreate(&record).Error; err != nil {
+            return err
+        }
+        updates := map[string]interface{}{"size": size, "content_type": contentType, "sha256": sum}
+        if err := tx.Model(&record).Updates(updates).Error; err != nil {
+            return err
+        }
+        if todoID == nil {
+            return nil
+        }
+        return attachFile(tx, *todoID, record.ID)
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    // The old thumbnail no longer matches, regenerate it from the new content
+    if err := s.storage.Delete(thumbnailName(fileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+        log.Printf("Failed to delete thumbnail for %s: %v", fileName, err)
+    }
+    response := map[string]string{"file_path": s.storage.Location(fileName)}
+    if s.generateThumbnail(fileName) {
+        response["thumbnail"] = fileName
+    }
+
+    writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
+    matches, err := parseFileFilter(r.URL.Query())
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    less, err := parseFileSort(r.URL.Query())
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    files, err := s.storage.List()
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    // ?folder=name only lists the files moved into that folder
+    if folder := r.URL.Query().Get("folder"); folder != "" {
+        inFolder, err := s.folderFileNames(folder)
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        filtered := files[:0]
+        for _, file := range files {
+            if inFolder[file.Name] {
+                filtered = append(filtered, file)
+            }
+        }
+        files = filtered
+    }
+    if r.URL.Query().Get("sort") == "last_accessed" {
+        if err := s.withLastAccessed(files); err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+    }
+
+    var infos []FileInfo
+    for _, file := range files {
+        if matches(file.Name) {
+            infos = append(infos, file)
+        }
+    }
+    sort.SliceStable(infos, func(i, j int) bool {
+        return less(infos[i], infos[j])
+    })
+
+    fileNames := []string{}
+    for _, info := range infos {
+        fileNames = append(fileNames, info.Name)
+    }
+
+    writeJSON(w, http.StatusOK, fileNames)
+}
+
+// downloadFile also answers HEAD with the same headers and no body, so clients
+// can check a file's size and existence without fetching it
+func (s *Server) downloadFile(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    fileName := vars["filename"]
+
+    info, err := s.statFile(fileName)
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+    w.Header().Set("Content-Type", "application/octet-stream")
+    w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+    w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+    if r.Method == http.MethodHead {
+        return
+    }
+
+    file, err := s.storage.Open(fileName)
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    defer file.Close()
+    s.touchFile(fileName)
+
+    io.Copy(w, file)
+}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// downloadByHash serves a file by the SHA-256 of its content. Content at a
+// hash never changes, so it can be cached forever.
+func (s *Server) downloadByHash(w http.ResponseWriter, r *http.Request) {
+    sum := strings.ToLower(mux.Vars(r)["sha256"])
+    if !sha256Pattern.MatchString(sum) {
+        writeError(w, http.StatusBadRequest, "sha256 must be 64 hex characters")
+        return
+    }
+
+    record, err := s.fileByHash(sum)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    etag := `"` + sum + `"`
+    w.Header().Set("ETag", etag)
+    w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+    if r.Header.Get("If-None-Match") == etag {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    file, err := s.storage.Open(record.Name)
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    defer file.Close()
+    s.touchFile(record.Name)
+
+    contentType := record.ContentType
+    if contentType == "" {
+        contentType = "application/octet-stream"
+    }
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", record.Name))
+    io.Copy(w, file)
+}
+
+// removeFile deletes a stored upload along with its thumbnail and metadata
+func (s *Server) removeFile(fileName string) error {
+    if err := s.storage.Delete(fileName); err != nil {
+        return err
+    }
+    if err := s.storage.Delete(thumbnailName(fileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+        log.Printf("Failed to delete thumbnail for %s: %v", fileName, err)
+    }
+    if err := deleteFileRecord(s.db, fileName); err != nil {
+        log.Printf("Failed to delete metadata for %s: %v", fileName, err)
+    }
+    return nil
+}
+
+// deleteFile removes a stored file. With ?todo=<uuid> it is only detached from
+// that todo, and the content goes once no other todo uses it. A file several
+// todos share is never removed from under them: without ?todo= that is a 409.
+func (s *Server) deleteFile(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    fileName := vars["filename"]
+
+    if todoUUID := r.URL.Query().Get("todo"); todoUUID != "" {
+        var todo Todo
+        err := s.db.Select("id", "file_path").Where("uuid = ?", todoUUID).First(&todo).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            writeError(w, http.StatusNotFound, "todo not found")
+            return
+        }
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        err = s.db.Transaction(func(tx *gorm.DB) error {
+            ids := tx.Session(&gorm.Session{NewDB: true}).Model(&File{}).Select("id").Where("name = ?", fileName)
+            if err := tx.Exec("DELETE FROM file_attachments WHERE todo_id = ? AND file_id IN (?)", todo.ID, ids).Error; err != nil {
+                return err
+            }
+            return tx.Model(&Todo{}).Where("id = ? AND file_path = ?", todo.ID, s.storage.Location(fileName)).Update("file_path", "").Error
+        })
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+    }
+
+    users, err := s.fileUsers(s.db, fileName)
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if len(users) > 0 && (r.URL.Query().Has("todo") || len(users) > 1) {
+        uuids := make([]string, len(users))
+        for i, todo := range users {
+            uuids[i] = todo.UUID
+        }
+        if r.URL.Query().Has("todo") {
+            // Detached, the content stays for the todos still using it
+            writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": false, "todos": uuids})
+            return
+        }
+        writeJSON(w, http.StatusConflict, map[string]interface{}{
+            "error": fmt.Sprintf("file is used by %d todos, pass ?todo= to detach it from one", len(users)),
+            "todos": uuids,
+        })
+        return
+    }
+
+    remove := s.removeFile
+    if s.config.FileTrashRetention > 0 {
+        remove = s.trashFile
+    }
+    err = remove(fileName)
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+}
+
+// deleteOldFiles removes every upload last modified before ?older_than=, or
+// not downloaded since ?not_accessed_since=. One of them is required so a bare
+// DELETE can't wipe everything. The todos whose file_path pointed at a removed
+// file are listed under references.
+func (s *Server) deleteOldFiles(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    param := "older_than"
+    if query.Has("not_accessed_since") {
+        param = "not_accessed_since"
+        if query.Has("older_than") {
+            writeError(w, http.StatusBadRequest, "use either older_than or not_accessed_since, not both")
+            return
+        }
+    }
+    value := query.Get(param)
+    if value == "" {
+        writeError(w, http.StatusBadRequest, "older_than or not_accessed_since is required")
+        return
+    }
+    cutoff, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q, must be an RFC 3339 timestamp", param, value))
+        return
+    }
+
+    files, err := s.storage.List()
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    if param == "not_accessed_since" {
+        if err := s.withLastAccessed(files); err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+    }
+
+    // Like DELETE /files/{filename}, files go to the trash while it is on
+    remove := s.removeFile
+    if s.config.FileTrashRetention > 0 {
+        remove = s.trashFile
+    }
+
+    deleted := []string{}
+    var locations []string
+    var reclaimed int64
+    for _, file := range files {
+        seen := file.ModTime
+        if param == "not_accessed_since" {
+            seen = file.LastAccessed
+        }
+        if !seen.Before(cutoff) {
+            continue
+        }
+        if err := remove(file.Name); err != nil {
+            if errors.Is(err, fs.ErrNotExist) {
+                continue
+            }
+            writeStorageError(w, err)
+            return
+        }
+        deleted = append(deleted, file.Name)
+        locations = append(locations, s.storage.Location(file.Name))
+        reclaimed += file.Size
+    }
+    sort.Strings(deleted)
+
+    // Todos whose file_path pointed at a removed file. Trashed files keep the
+    // reference so a restore brings it back, deleted ones have it cleared.
+    references := []danglingReference{}
+    if len(locations) > 0 {
+        var todos []Todo
+        if err := s.db.Select("id", "uuid", "file_path").Where("file_path IN ?", locations).Order("id").Find(&todos).Error; err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        for _, todo := range todos {
+            references = append(references, danglingReference{UUID: todo.UUID, FilePath: todo.FilePath})
+        }
+        if s.config.FileTrashRetention <= 0 && len(todos) > 0 {
+            if err := s.db.Model(&Todo{}).Where("file_path IN ?", locations).Update("file_path", "").Error; err != nil {
+                writeError(w, http.StatusInternalServerError, err.Error())
+                return
+            }
+        }
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "deleted":         deleted,
+        "reclaimed_bytes": reclaimed,
+        "trashed":         s.config.FileTrashRetention > 0,
+        "references":      references,
+    })
+}
+
+// sanitizeFileName rejects names that are empty, contain path separators or
+// control characters, or would otherwise resolve outside the uploads directory.
+func sanitizeFileName(name string) (string, error) {
+    name = strings.TrimSpace(name)
+    if name == "" || name == "." || name == ".." {
+        return "", fmt.Errorf("invalid file name %q", name)
+    }
+    if strings.ContainsAny(name, `/\`) {
+        return "", fmt.Errorf("file name must not contain path separators")
+    }
+    for _, c := range name {
+        if c < 0x20 || c == 0x7f {
+            return "", fmt.Errorf("file name must not contain control characters")
+        }
+    }
+    if len(name) > 255 {
+        return "", fmt.Errorf("file name is too long")
+    }
+    return name, nil
+}
+
+func (s *Server) renameFile(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    fileName, err := sanitizeFileName(vars["filename"])
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    var body struct {
+        NewName string `json:"new_name"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    newName, err := sanitizeFileName(body.NewName)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    if _, err := s.storage.Stat(fileName); errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    } else if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    if _, err := s.storage.Stat(newName); err == nil {
+        writeError(w, http.StatusConflict, "a file with that name already exists")
+        return
+    }
+
+    if err := s.storage.Rename(fileName, newName); err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    if err := s.storage.Rename(thumbnailName(fileName), thumbnailName(newName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+        log.Printf("Failed to rename thumbnail for %s: %v", fileName, err)
+    }
+
+    // Keep todos pointing at the file after the rename
+    oldLocation, newLocation := s.storage.Location(fileName), s.storage.Location(newName)
+    result := s.db.Model(&Todo{}).Where("file_path = ?", oldLocation).Update("file_path", newLocation)
+    if result.Error != nil {
+        writeError(w, http.StatusInternalServerError, result.Error.Error())
+        return
+    }
+    if err := s.db.Model(&File{}).Where("name = ?", fileName).Update("name", newName).Error; err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]string{"file_path": newLocation})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// Content-type groups used by ?type= on the file list, keyed by extension
+var fileTypeGroups = map[string][]string{
+    "image":    {".png", ".jpg", ".jpeg", ".gif", ".bmp", ".webp", ".svg", ".tif", ".tiff", ".ico", ".heic"},
+    "document": {".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".odt", ".ods", ".odp", ".rtf", ".txt", ".md", ".csv"},
+    "archive":  {".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".rar"},
+}
+
+func fileTypeGroup(name string) string {
+    ext := strings.ToLower(filepath.Ext(name))
+    for group, extensions := range fileTypeGroups {
+        if contains(extensions, ext) {
+            return group
+        }
+    }
+    return "other"
+}
+
+// parseFileFilter builds a predicate from ?ext=png,jpg and ?type=image
+func parseFileFilter(query url.Values) (func(name string) bool, error) {
+    var extensions []string
+    for _, value := range strings.Split(query.Get("ext"), ",") {
+        value = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "."))
+        if value != "" {
+            extensions = append(extensions, "."+value)
+        }
+    }
+
+    group := strings.ToLower(query.Get("type"))
+    if group != "" && group != "other" {
+        if _, ok := fileTypeGroups[group]; !ok {
+            groups := []string{"other"}
+            for name := range fileTypeGroups {
+                groups = append(groups, name)
+            }
+            sort.Strings(groups)
+            return nil, fmt.Errorf("invalid type %q, must be one of %s", group, strings.Join(groups, ", "))
+        }
+    }
+
+    return func(name string) bool {
+        if len(extensions) > 0 && !contains(extensions, strings.ToLower(filepath.Ext(name))) {
+            return false
+        }
+        if group != "" && fileTypeGroup(name) != group {
+            return false
+        }
+        return true
+    }, nil
+}
+
+// parseFileSort reads ?sort=size|name|modified|last_accessed&order=asc|desc,
+// defaulting to the most recently modified files first. last_accessed needs
+// FileInfo.LastAccessed filled in.
+func parseFileSort(query url.Values) (func(a, b FileInfo) bool, error) {
+    field := query.Get("sort")
+    if field == "" {
+        field = "modified"
+    }
+    order := query.Get("order")
+    if order == "" {
+        order = "desc"
+        if field == "name" {
+            order = "asc"
+        }
+    }
+    if order != "asc" && order != "desc" {
+        return nil, fmt.Errorf("invalid order %q, must be asc or desc", order)
+    }
+
+    var less func(a, b FileInfo) bool
+    switch field {
+    case "name":
+        less = func(a, b FileInfo) bool { return a.Name < b.Name }
+    case "size":
+        less = func(a, b FileInfo) bool { return a.Size < b.Size }
+    case "modified":
+        less = func(a, b FileInfo) bool { return a.ModTime.Before(b.ModTime) }
+    case "last_accessed":
+        less = func(a, b FileInfo) bool { return a.LastAccessed.Before(b.LastAccessed) }
+    default:
+        return nil, fmt.Errorf("invalid sort %q, must be one of name, size, modified, last_accessed", field)
+    }
+
+    if order == "desc" {
+        return func(a, b FileInfo) bool { return less(b, a) }, nil
+    }
+    return less, nil
+}
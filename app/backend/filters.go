@@ -0,0 +1,164 @@
+package main
+
+import (
+    "fmt"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Query parameters understood by parseTodoFilters
+var todoFilterParams = []string{"completed", "has_file", "q", "uuid", "due", "archived", "completed_before", "attachment_type"}
+
+// hasTodoFilters reports whether the request narrows the todo set at all
+func hasTodoFilters(r *http.Request) bool {
+    query := r.URL.Query()
+    for _, name := range todoFilterParams {
+        // archived=false is the default scope and archived=all widens it,
+        // so only archived=true narrows
+        if value := query.Get(name); value != "" && !(name == "archived" && value != "true") {
+            return true
+        }
+    }
+    return false
+}
+
+// matchText is a case-insensitive substring match on the title and
+// description. Encrypted descriptions are ciphertext in the database, so with
+// FIELD_ENCRYPTION_KEY set only the title is searched.
+func matchText(tx *gorm.DB, text string, encrypted bool) *gorm.DB {
+    pattern := "%" + text + "%"
+    if encrypted {
+        return tx.Where("title ILIKE ?", pattern)
+    }
+    return tx.Where("title ILIKE ? OR description ILIKE ?", pattern, pattern)
+}
+
+// parseTodoFilters turns the list query string into a gorm scope so the same
+// filters apply to listing and to bulk operations.
+func (s *Server) parseTodoFilters(r *http.Request) (func(*gorm.DB) *gorm.DB, error) {
+    query := r.URL.Query()
+    var conditions []func(*gorm.DB) *gorm.DB
+
+    if value := query.Get("completed"); value != "" {
+        completed, err := strconv.ParseBool(value)
+        if err != nil {
+            return nil, fmt.Errorf("invalid completed value %q", value)
+        }
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            return tx.Where("completed = ?", completed)
+        })
+    }
+
+    // Archived todos are left out unless ?archived=true (only them) or all
+    switch value := query.Get("archived"); value {
+    case "all":
+    case "", "false", "true":
+        archived := value == "true"
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            return tx.Where("archived = ?", archived)
+        })
+    default:
+        return nil, fmt.Errorf("invalid archived value %q, must be true, false or all", value)
+    }
+
+    if value := query.Get("has_file"); value != "" {
+        hasFile, err := strconv.ParseBool(value)
+        if err != nil {
+            return nil, fmt.Errorf("invalid has_file value %q", value)
+        }
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            if hasFile {
+                return tx.Where("file_path <> ''")
+            }
+            return tx.Where("file_path = '' OR file_path IS NULL")
+        })
+    }
+
+    if text := query.Get("q"); text != "" {
+        encrypted := s.config.FieldCipher != nil
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            return matchText(tx, text, encrypted)
+        })
+    }
+
+    // ?uuid=a&uuid=b, capped like the batch-get endpoint
+    if uuids := query["uuid"]; len(uuids) > 0 {
+        if len(uuids) > maxBatchUUIDs {
+            return nil, fmt.Errorf("at most %d uuid values are allowed, got %d", maxBatchUUIDs, len(uuids))
+        }
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            return tx.Where("uuid IN ?", uuids)
+        })
+    }
+
+    // ?due=today|this_week, resolved in the server's timezone (TZ)
+    if value := query.Get("due"); value != "" {
+        start, end, err := dueRange(value, time.Now(), s.config.WeekStart)
+        if err != nil {
+            return nil, err
+        }
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            return tx.Where("due_date >= ? AND due_date < ?", start, end)
+        })
+    }
+
+    // ?completed_before=<RFC 3339>, e.g. to pick out long-finished todos
+    if value := query.Get("completed_before"); value != "" {
+        before, err := time.Parse(time.RFC3339, value)
+        if err != nil {
+            return nil, fmt.Errorf("invalid completed_before %q, must be an RFC 3339 timestamp", value)
+        }
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            return tx.Where("completed_at < ?", before)
+        })
+    }
+
+    // ?attachment_type=application/pdf or image/*, matched against the
+    // attachments' content type without parameters such as charset
+    if value := query.Get("attachment_type"); value != "" {
+        mediaType, _, err := mime.ParseMediaType(value)
+        if err != nil || !strings.Contains(mediaType, "/") {
+            return nil, fmt.Errorf("invalid attachment_type %q, must be a media type such as application/pdf or image/*", value)
+        }
+        conditions = append(conditions, func(tx *gorm.DB) *gorm.DB {
+            attachments := tx.Session(&gorm.Session{NewDB: true}).
+                Table("file_attachments").
+                Select("file_attachments.todo_id").
+                Joins("JOIN files ON files.id = file_attachments.file_id")
+            if prefix, ok := strings.CutSuffix(mediaType, "*"); ok {
+                attachments = attachments.Where("LOWER(TRIM(SPLIT_PART(files.content_type, ';', 1))) LIKE ?", prefix+"%")
+            } else {
+                attachments = attachments.Where("LOWER(TRIM(SPLIT_PART(files.content_type, ';', 1))) = ?", mediaType)
+            }
+            return tx.Where("id IN (?)", attachments)
+        })
+    }
+
+    return func(tx *gorm.DB) *gorm.DB {
+        for _, condition := range conditions {
+            tx = condition(tx)
+        }
+        return tx
+    }, nil
+}
+
+// dueRange resolves a relative due filter to a [start, end) range in now's
+// location, with weeks starting on weekStart.
+func dueRange(name string, now time.Time, weekStart time.Weekday) (time.Time, time.Time, error) {
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    switch name {
+    case "today":
+        return today, today.AddDate(0, 0, 1), nil
+    case "this_week":
+        offset := (int(today.Weekday()) - int(weekStart) + 7) % 7
+        start := today.AddDate(0, 0, -offset)
+        return start, start.AddDate(0, 0, 7), nil
+    default:
+        return time.Time{}, time.Time{}, fmt.Errorf("invalid due %q, must be today or this_week", name)
+    }
+}
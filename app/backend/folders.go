@@ -0,0 +1,152 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "net/http"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Folder is a logical grouping of uploads. Files stay flat in storage, the
+// folder only lives in their metadata.
+type Folder struct {
+    ID        uint      `json:"-" gorm:"primarykey"`
+    Name      string    `json:"name" gorm:"uniqueIndex;not null"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// Letters, digits, spaces, dots, dashes and underscores; no separators, so a
+// folder name can never be used to walk out of anything
+var folderNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._-]{0,63}$`)
+
+func validateFolderName(name string) (string, error) {
+    name = strings.TrimSpace(name)
+    if !folderNamePattern.MatchString(name) || strings.Contains(name, "..") {
+        return "", fmt.Errorf("invalid folder name %q: use up to 64 letters, digits, spaces, dots, dashes or underscores", name)
+    }
+    return name, nil
+}
+
+func (s *Server) createFolder(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        Name string `json:"name"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    name, err := validateFolderName(body.Name)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    folder := Folder{Name: name}
+    err = s.db.Create(&folder).Error
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        writeError(w, http.StatusConflict, "folder already exists")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusCreated, folder)
+}
+
+// listFolders returns every folder with the number of files in it
+func (s *Server) listFolders(w http.ResponseWriter, r *http.Request) {
+    folders := []struct {
+        Name      string    `json:"name"`
+        CreatedAt time.Time `json:"created_at"`
+        Files     int64     `json:"files"`
+    }{}
+    err := s.withReadRetry(func() error {
+        return s.db.Table("folders").
+            Select("folders.name, folders.created_at, COUNT(files.id) AS files").
+            Joins("LEFT JOIN files ON files.folder = folders.name").
+            Group("folders.id, folders.name, folders.created_at").
+            Order("folders.name").
+            Scan(&folders).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, folders)
+}
+
+// moveFile puts a file into a folder, or back at the top level when folder
+// is empty
+func (s *Server) moveFile(w http.ResponseWriter, r *http.Request) {
+    fileName, err := sanitizeFileName(mux.Vars(r)["filename"])
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    var body struct {
+        Folder string `json:"folder"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    folder := strings.TrimSpace(body.Folder)
+    if folder != "" {
+        if folder, err = validateFolderName(folder); err != nil {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        err := s.db.Where("name = ?", folder).First(&Folder{}).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            writeError(w, http.StatusNotFound, "folder not found")
+            return
+        }
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+    }
+
+    info, err := s.storage.Stat(fileName)
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+
+    // Uploads from before file metadata existed get a record now
+    var record File
+    err = s.db.Where(File{Name: fileName}).Attrs(File{Size: info.Size}).FirstOrCreate(&record).Error
+    if err == nil {
+        err = s.db.Model(&record).Update("folder", folder).Error
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, record)
+}
+
+// folderFileNames returns the names of the files in folder
+func (s *Server) folderFileNames(folder string) (map[string]bool, error) {
+    var names []string
+    if err := s.db.Model(&File{}).Where("folder = ?", folder).Pluck("name", &names).Error; err != nil {
+        return nil, err
+    }
+    inFolder := make(map[string]bool, len(names))
+    for _, name := range names {
+        inFolder[name] = true
+    }
+    return inFolder, nil
+}
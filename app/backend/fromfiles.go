@@ -0,0 +1,141 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "path/filepath"
+
+    "gorm.io/gorm"
+)
+
+// Upper bound on files accepted by one POST /todos/from-files
+const maxFilesPerRequest = 50
+
+type fromFileResult struct {
+    File      string `json:"file"`
+    StoredAs  string `json:"stored_as,omitempty"`
+    TodoUUID  string `json:"todo_uuid,omitempty"`
+    Thumbnail bool   `json:"thumbnail,omitempty"`
+    Error     string `json:"error,omitempty"`
+}
+
+// createTodosFromFiles stores every uploaded "files" part and creates one todo
+// per file, titled after it, with the file attached. The todos are created in
+// a single transaction; if anything fails nothing is kept.
+func (s *Server) createTodosFromFiles(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseMultipartForm(s.config.MultipartMaxMemory); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    headers := r.MultipartForm.File["files"]
+    if len(headers) == 0 {
+        writeError(w, http.StatusBadRequest, "at least one file is required in the files field")
+        return
+    }
+    if len(headers) > maxFilesPerRequest {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d files can be uploaded at once", maxFilesPerRequest))
+        return
+    }
+
+    if s.config.MaxUploadDirBytes > 0 {
+        var incoming int64
+        for _, header := range headers {
+            incoming += header.Size
+        }
+        used, err := s.storageUsage()
+        if err != nil {
+            writeStorageError(w, err)
+            return
+        }
+        if used+incoming > s.config.MaxUploadDirBytes {
+            writeQuotaExceeded(w, http.StatusInsufficientStorage, "storage", s.config.MaxUploadDirBytes, used, nil,
+                fmt.Sprintf("upload would exceed the storage limit (%d of %d bytes used)", used, s.config.MaxUploadDirBytes))
+            return
+        }
+    }
+
+    results := make([]fromFileResult, len(headers))
+    records := make([]File, len(headers))
+    // Content that was already stored under hash naming, it keeps its record
+    reused := make([]bool, len(headers))
+    var saved []string
+    discard := func() {
+        for _, name := range saved {
+            s.storage.Delete(name)
+        }
+    }
+
+    failed := false
+    // A storage outage turns the 500 into a 503/507, see storageErrorStatus
+    failStatus := http.StatusInternalServerError
+    for i, header := range headers {
+        original := filepath.Base(header.Filename)
+        results[i].File = original
+
+        file, err := header.Open()
+        if err != nil {
+            results[i].Error = err.Error()
+            failed = true
+            continue
+        }
+        record, wasReused, err := s.storeUpload(original, file, header.Size)
+        file.Close()
+        if err != nil {
+            results[i].Error = err.Error()
+            if status, _ := storageErrorStatus(err); status != http.StatusInternalServerError {
+                failStatus = status
+            }
+            failed = true
+            continue
+        }
+        if !wasReused {
+            saved = append(saved, record.Name)
+        }
+        reused[i] = wasReused
+        results[i].StoredAs = record.Name
+        records[i] = record
+    }
+    if failed {
+        discard()
+        writeJSON(w, failStatus, map[string]interface{}{
+            "error":   "some files could not be stored, nothing was created",
+            "results": results,
+        })
+        return
+    }
+
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        for i := range records {
+            todo := Todo{
+                UUID:     s.newTodoUUID(),
+                Title:    results[i].File,
+                FilePath: s.storage.Location(records[i].Name),
+            }
+            if err := tx.Create(&todo).Error; err != nil {
+                return err
+            }
+            // A reused file is already recorded and gets one more todo
+            if err := tx.Where(File{Name: records[i].Name}).FirstOrCreate(&records[i]).Error; err != nil {
+                return err
+            }
+            if err := attachFile(tx, todo.ID, records[i].ID); err != nil {
+                return err
+            }
+            results[i].TodoUUID = todo.UUID
+        }
+        return nil
+    })
+    if err != nil {
+        discard()
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    for i := range records {
+        results[i].Thumbnail = s.generateThumbnail(records[i].Name)
+    }
+    writeJSON(w, http.StatusCreated, map[string]interface{}{
+        "created": len(records),
+        "results": results,
+    })
+}
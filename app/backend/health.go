@@ -0,0 +1,50 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// checkStorage proves uploads can be written by saving and deleting a probe
+// file, catching missing or read-only volumes and unreachable buckets.
+func (s *Server) checkStorage() error {
+    name := fmt.Sprintf(".readyz-%d", time.Now().UnixNano())
+    if err := s.storage.Save(name, strings.NewReader("ok"), 2); err != nil {
+        return err
+    }
+    return s.storage.Delete(name)
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+    checks := map[string]string{}
+    status := http.StatusOK
+
+    if sqlDB, err := s.db.DB(); err != nil {
+        checks["database"] = err.Error()
+        status = http.StatusServiceUnavailable
+    } else if err := sqlDB.PingContext(r.Context()); err != nil {
+        checks["database"] = err.Error()
+        status = http.StatusServiceUnavailable
+    } else {
+        checks["database"] = "ok"
+    }
+
+    if err := s.checkStorage(); err != nil {
+        // Same wording as the file endpoints, e.g. "file storage is full: ..."
+        _, message := storageErrorStatus(err)
+        if message != err.Error() {
+            message += ": " + err.Error()
+        }
+        checks["storage"] = message
+        status = http.StatusServiceUnavailable
+    } else {
+        checks["storage"] = "ok"
+    }
+
+    writeJSON(w, status, map[string]interface{}{
+        "ready":  status == http.StatusOK,
+        "checks": checks,
+    })
+}
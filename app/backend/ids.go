@@ -0,0 +1,75 @@
+package main
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/google/uuid"
+    "gorm.io/gorm"
+)
+
+// Largest integer a JavaScript number holds exactly (Number.MAX_SAFE_INTEGER)
+const maxSafeJSONInteger = 1<<53 - 1
+
+// jsonID is a numeric primary key that serialises as a JSON number while it
+// is safe for JavaScript clients and as a string beyond that. Both forms are
+// accepted when decoding.
+type jsonID uint
+
+func (id jsonID) MarshalJSON() ([]byte, error) {
+    if id > maxSafeJSONInteger {
+        return json.Marshal(strconv.FormatUint(uint64(id), 10))
+    }
+    return []byte(strconv.FormatUint(uint64(id), 10)), nil
+}
+
+func (id *jsonID) UnmarshalJSON(data []byte) error {
+    var text string
+    if err := json.Unmarshal(data, &text); err != nil {
+        text = string(data)
+    }
+    value, err := strconv.ParseUint(text, 10, 64)
+    if err != nil {
+        return fmt.Errorf("invalid id %s", data)
+    }
+    *id = jsonID(value)
+    return nil
+}
+
+func (id jsonID) Value() (driver.Value, error) {
+    return int64(id), nil
+}
+
+func (id *jsonID) Scan(value interface{}) error {
+    switch v := value.(type) {
+    case int64:
+        *id = jsonID(v)
+    case nil:
+        *id = 0
+    default:
+        return fmt.Errorf("cannot scan %T into an id", value)
+    }
+    return nil
+}
+
+// Model is gorm.Model with a jsonID primary key, the JSON shape is unchanged
+type Model struct {
+    ID        jsonID `gorm:"primarykey"`
+    CreatedAt time.Time
+    UpdatedAt time.Time
+    DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// newTodoUUID generates a todo UUID of the configured UUID_VERSION. Version 7
+// starts with a timestamp, so newer todos sort after older ones.
+func (s *Server) newTodoUUID() string {
+    if s.config.UUIDVersion == 7 {
+        if id, err := uuid.NewV7(); err == nil {
+            return id.String()
+        }
+    }
+    return uuid.New().String()
+}
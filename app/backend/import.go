@@ -0,0 +1,326 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "sort"
+    "time"
+
+    "github.com/google/uuid"
+    "gorm.io/gorm"
+)
+
+// Cap on how many validation problems are reported for a single import
+const maxImportErrors = 100
+
+type fieldSchema struct {
+    kind     string // JSON type: string, number, boolean, array
+    items    string // element type for arrays
+    required bool
+    nullable bool
+    enum     []string
+}
+
+// Shape of a single todo in an import file. The Model fields and derived
+// fields such as download_url are accepted so backups taken from GET /todos can
+// be imported unchanged, but are ignored.
+var todoImportSchema = map[string]fieldSchema{
+    "uuid":         {kind: "string"},
+    "title":        {kind: "string", required: true},
+    "description":  {kind: "string"},
+    "completed":    {kind: "boolean"},
+    "file_path":    {kind: "string"},
+    "tags":         {kind: "array", items: "string", nullable: true},
+    "due_date":     {kind: "string", nullable: true},
+    "completed_at": {kind: "string", nullable: true},
+    "archived":     {kind: "boolean"},
+    "owner_id":     {kind: "string"},
+    "download_url": {kind: "string"},
+    "is_overdue":   {kind: "boolean"},
+    "attachments":  {kind: "array", items: "object", nullable: true},
+    "position":     {kind: "number"},
+    "ID":           {kind: "number"},
+    "CreatedAt":    {kind: "string"},
+    "UpdatedAt":    {kind: "string"},
+    "DeletedAt":    {kind: "string", nullable: true},
+}
+
+type importError struct {
+    Index   int    `json:"index"`
+    Field   string `json:"field,omitempty"`
+    Message string `json:"message"`
+}
+
+func jsonKind(raw json.RawMessage) string {
+    var value interface{}
+    if err := json.Unmarshal(raw, &value); err != nil {
+        return "invalid"
+    }
+    switch value.(type) {
+    case nil:
+        return "null"
+    case string:
+        return "string"
+    case float64:
+        return "number"
+    case bool:
+        return "boolean"
+    case []interface{}:
+        return "array"
+    default:
+        return "object"
+    }
+}
+
+func validateImportItem(index int, raw json.RawMessage) []importError {
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+        return []importError{{Index: index, Message: "item must be a JSON object"}}
+    }
+
+    names := make([]string, 0, len(todoImportSchema))
+    for name := range todoImportSchema {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var errs []importError
+    for _, name := range names {
+        schema := todoImportSchema[name]
+        value, ok := fields[name]
+        if !ok {
+            if schema.required {
+                errs = append(errs, importError{Index: index, Field: name, Message: "is required"})
+            }
+            continue
+        }
+
+        kind := jsonKind(value)
+        if kind == "null" && schema.nullable {
+            continue
+        }
+        if kind != schema.kind {
+            errs = append(errs, importError{Index: index, Field: name, Message: fmt.Sprintf("must be a %s, got %s", schema.kind, kind)})
+            continue
+        }
+
+        if schema.kind == "array" {
+            var elements []json.RawMessage
+            json.Unmarshal(value, &elements)
+            for i, element := range elements {
+                if kind := jsonKind(element); kind != schema.items {
+                    errs = append(errs, importError{Index: index, Field: fmt.Sprintf("%s[%d]", name, i), Message: fmt.Sprintf("must be a %s, got %s", schema.items, kind)})
+                    continue
+                }
+                if name == "tags" {
+                    var tag string
+                    json.Unmarshal(element, &tag)
+                    if _, err := normalizeTagName(tag); err != nil {
+                        errs = append(errs, importError{Index: index, Field: fmt.Sprintf("%s[%d]", name, i), Message: err.Error()})
+                    }
+                }
+            }
+        }
+
+        if schema.kind == "string" {
+            var s string
+            json.Unmarshal(value, &s)
+            if schema.required && s == "" {
+                errs = append(errs, importError{Index: index, Field: name, Message: "must not be empty"})
+            }
+            if len(schema.enum) > 0 && !contains(schema.enum, s) {
+                errs = append(errs, importError{Index: index, Field: name, Message: fmt.Sprintf("must be one of %v", schema.enum)})
+            }
+            if name == "owner_id" && len(s) > maxOwnerIDLength {
+                errs = append(errs, importError{Index: index, Field: name, Message: fmt.Sprintf("must be at most %d bytes", maxOwnerIDLength)})
+            }
+            if name == "uuid" && s != "" {
+                if _, err := uuid.Parse(s); err != nil {
+                    errs = append(errs, importError{Index: index, Field: name, Message: "must be a valid UUID"})
+                }
+            }
+            if name == "due_date" || name == "completed_at" {
+                if _, err := time.Parse(time.RFC3339, s); err != nil {
+                    errs = append(errs, importError{Index: index, Field: name, Message: "must be an RFC 3339 timestamp"})
+                }
+            }
+        }
+    }
+
+    for name := range fields {
+        if _, ok := todoImportSchema[name]; !ok {
+            errs = append(errs, importError{Index: index, Field: name, Message: "unknown field"})
+        }
+    }
+    return errs
+}
+
+func contains(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
+
+// Todos inserted per batch while streaming an import
+const importBatchSize = 500
+
+var errImportInvalid = errors.New("import validation failed")
+
+// importItem converts a validated import item into a todo and its tag names
+func importItem(raw json.RawMessage) (Todo, []string) {
+    var item struct {
+        UUID        string     `json:"uuid"`
+        Title       string     `json:"title"`
+        Description string     `json:"description"`
+        Completed   bool       `json:"completed"`
+        FilePath    string     `json:"file_path"`
+        Tags        []string   `json:"tags"`
+        DueDate     *time.Time `json:"due_date"`
+        CompletedAt *time.Time `json:"completed_at"`
+        Archived    bool       `json:"archived"`
+        OwnerID     string     `json:"owner_id"`
+        Position    int        `json:"position"`
+    }
+    json.Unmarshal(raw, &item)
+
+    todo := Todo{
+        UUID:        item.UUID,
+        Title:       item.Title,
+        Description: item.Description,
+        Completed:   item.Completed,
+        FilePath:    item.FilePath,
+        DueDate:     item.DueDate,
+        CompletedAt: item.CompletedAt,
+        Archived:    item.Archived,
+        OwnerID:     item.OwnerID,
+        Position:    item.Position,
+    }
+    // Backups from before completed_at existed start the clock at import
+    if !todo.Completed {
+        todo.CompletedAt = nil
+    } else if todo.CompletedAt == nil {
+        todo.CompletedAt = completedAt(true)
+    }
+    return todo, item.Tags
+}
+
+// importTodos streams the JSON array one element at a time and inserts in
+// batches, so memory use doesn't grow with the size of the file. Everything
+// happens in one transaction: if any item is invalid nothing is imported.
+func (s *Server) importTodos(w http.ResponseWriter, r *http.Request) {
+    decoder := json.NewDecoder(r.Body)
+    token, err := decoder.Token()
+    if errors.Is(err, io.EOF) {
+        err = errEmptyBody
+    }
+    if err == nil && token != json.Delim('[') {
+        err = errors.New("expected an array")
+    }
+    if err != nil {
+        writeError(w, http.StatusBadRequest, "request body must be a JSON array of todos: "+describeDecodeError(err).Error())
+        return
+    }
+
+    var errs []importError
+    var syntaxErr error
+    imported := 0
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        batch := make([]Todo, 0, importBatchSize)
+        batchTags := make([][]string, 0, importBatchSize)
+        flush := func() error {
+            if len(batch) == 0 {
+                return nil
+            }
+            if err := tx.Create(&batch).Error; err != nil {
+                return err
+            }
+            for i := range batch {
+                if len(batchTags[i]) > 0 {
+                    if err := setTodoTags(tx, &batch[i], batchTags[i]); err != nil {
+                        return err
+                    }
+                }
+            }
+            imported += len(batch)
+            log.Printf("Import progress: %d todos inserted", imported)
+            batch, batchTags = batch[:0], batchTags[:0]
+            return nil
+        }
+
+        for index := 0; decoder.More(); index++ {
+            var raw json.RawMessage
+            if err := decoder.Decode(&raw); err != nil {
+                syntaxErr = err
+                return err
+            }
+            itemErrs := validateImportItem(index, raw)
+            var todo Todo
+            var tags []string
+            if len(itemErrs) == 0 {
+                todo, tags = importItem(raw)
+                if todo.UUID == "" {
+                    todo.UUID = s.newTodoUUID()
+                }
+                if err := s.checkDescription(todo.Description); err != nil {
+                    itemErrs = append(itemErrs, importError{Index: index, Field: "description", Message: err.Error()})
+                }
+            }
+            if len(itemErrs) > 0 {
+                errs = append(errs, itemErrs...)
+                if len(errs) >= maxImportErrors {
+                    errs = errs[:maxImportErrors]
+                    return errImportInvalid
+                }
+                continue
+            }
+            // Once anything is invalid keep validating, but stop inserting
+            if len(errs) > 0 {
+                continue
+            }
+
+            batch = append(batch, todo)
+            batchTags = append(batchTags, tags)
+            if len(batch) == importBatchSize {
+                if err := flush(); err != nil {
+                    return err
+                }
+            }
+        }
+        if len(errs) > 0 {
+            return errImportInvalid
+        }
+        if _, err := decoder.Token(); err != nil {
+            syntaxErr = err
+            return err
+        }
+        return flush()
+    })
+    if syntaxErr != nil {
+        writeError(w, http.StatusBadRequest, "request body must be a JSON array of todos: "+describeDecodeError(syntaxErr).Error())
+        return
+    }
+    if errors.Is(err, errImportInvalid) {
+        writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+            "error":  "import validation failed",
+            "errors": errs,
+        })
+        return
+    }
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        writeError(w, http.StatusConflict, "import contains a uuid that already exists")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, map[string]int{"imported": imported})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "mime"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// wantsJSONAPI reports whether the client negotiated JSON:API via Accept
+func wantsJSONAPI(r *http.Request) bool {
+    for _, value := range r.Header.Values("Accept") {
+        for _, part := range strings.Split(value, ",") {
+            mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+            if err == nil && mediaType == jsonAPIMediaType {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+type jsonAPIResource struct {
+    Type       string                     `json:"type"`
+    ID         string                     `json:"id"`
+    Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// todoResource wraps a todo as a JSON:API resource identified by its uuid,
+// honouring ?fields= for the attributes.
+func todoResource(todo Todo, fields []string) (jsonAPIResource, error) {
+    attributes, err := selectFields(todo, fields)
+    if err != nil {
+        return jsonAPIResource{}, err
+    }
+    delete(attributes, "uuid")
+    return jsonAPIResource{Type: "todos", ID: todo.UUID, Attributes: attributes}, nil
+}
+
+func writeJSONAPI(w http.ResponseWriter, status int, document interface{}) {
+    w.Header().Set("Content-Type", jsonAPIMediaType)
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(document)
+}
+
+// pageLinks builds the JSON:API pagination links, keeping the request's other
+// query parameters.
+func pageLinks(r *http.Request, meta PageMeta) map[string]string {
+    link := func(number int64) string {
+        query := r.URL.Query()
+        query.Set("page", strconv.FormatInt(number, 10))
+        query.Set("page_size", strconv.Itoa(meta.PageSize))
+        return (&url.URL{Path: r.URL.Path, RawQuery: query.Encode()}).String()
+    }
+
+    lastPage := meta.TotalPages
+    if lastPage < 1 {
+        lastPage = 1
+    }
+    current := int64(meta.Page)
+    links := map[string]string{
+        "self":  link(current),
+        "first": link(1),
+        "last":  link(lastPage),
+    }
+    if current > 1 {
+        links["prev"] = link(min(current-1, lastPage))
+    }
+    if current < lastPage {
+        links["next"] = link(current + 1)
+    }
+    return links
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "log"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+// Query parameters that carry credentials, such as the calendar feed's
+// ?token=, and are never written to the access log
+var sensitiveQueryParams = []string{"token"}
+
+// loggedURI is the request URI with sensitive query values replaced
+func loggedURI(u *url.URL) string {
+    query := u.Query()
+    redacted := false
+    for _, name := range sensitiveQueryParams {
+        if query.Has(name) {
+            query.Set(name, "REDACTED")
+            redacted = true
+        }
+    }
+    if !redacted {
+        return u.RequestURI()
+    }
+    clean := *u
+    clean.RawQuery = query.Encode()
+    return clean.RequestURI()
+}
+
+// statusRecorder captures the status and size of a response for logging
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+    if rec.status == 0 {
+        rec.status = status
+    }
+    rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+    if rec.status == 0 {
+        rec.status = http.StatusOK
+    }
+    n, err := rec.ResponseWriter.Write(b)
+    rec.bytes += int64(n)
+    return n, err
+}
+
+// Flush keeps streaming responses such as exports working through the wrapper
+func (rec *statusRecorder) Flush() {
+    if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+    return rec.ResponseWriter
+}
+
+// logRequests logs one line per request. Non-2xx and slow responses are
+// always logged, the rest only at LOG_SAMPLE_RATE.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r)
+        elapsed := time.Since(start)
+
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+        success := rec.status >= 200 && rec.status < 300
+        slow := elapsed >= s.config.LogSlowThreshold
+        if success && !slow && rand.Float64() >= s.config.LogSampleRate {
+            return
+        }
+        log.Printf("%s %s %d %dB %v %s", r.Method, loggedURI(r.URL), rec.status, rec.bytes, elapsed.Round(time.Millisecond), clientIP(r))
+    })
+}
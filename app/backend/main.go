@@ -2,44 +2,180 @@ package main
 
 import (
     "encoding/json"
+    "errors"
     "fmt"
-    "io"
     "log"
     "net/http"
+    "net/url"
     "os"
-    "path/filepath"
+    "strings"
     "time"
+    // Embedded zoneinfo so TZ works in the alpine image
+    _ "time/tzdata"
 
     "github.com/google/uuid"
     "github.com/gorilla/mux"
-    "github.com/rs/cors"
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+    "gorm.io/gorm/logger"
+    "gorm.io/gorm/schema"
 )
 
 type Todo struct {
-    gorm.Model
-    UUID        string `json:"uuid" gorm:"unique"`
-    Title       string `json:"title"`
-    Description string `json:"description"`
-    Completed   bool   `json:"completed"`
-    FilePath    string `json:"file_path,omitempty"`
+    Model
+    UUID        string     `json:"uuid" gorm:"unique"`
+    Title       string     `json:"title"`
+    Description string     `json:"description" gorm:"serializer:encrypted"`
+    Completed   bool       `json:"completed"`
+    // When the todo was last marked completed, nil while open
+    CompletedAt *time.Time `json:"completed_at,omitempty" gorm:"index"`
+    // Archived todos are hidden from lists unless ?archived= asks for them
+    Archived    bool       `json:"archived" gorm:"not null;default:false;index"`
+    // Set only by the admin transfer endpoint, empty when unassigned
+    OwnerID     string     `json:"owner_id,omitempty" gorm:"index;not null;default:''"`
+    FilePath    string     `json:"file_path,omitempty"`
+    DownloadURL string     `json:"download_url,omitempty" gorm:"-"`
+    DueDate     *time.Time `json:"due_date,omitempty" gorm:"index"`
+    IsOverdue   bool       `json:"is_overdue" gorm:"-"`
+    // Manual sort order set by PUT /todos/order, lower first
+    Position    int        `json:"position" gorm:"not null;default:0"`
+    Tags        []Tag      `json:"tags" gorm:"many2many:todo_tags"`
+    // Only loaded for ?include=attachments
+    Attachments []File `json:"attachments,omitempty" gorm:"many2many:file_attachments"`
+    // A client's temporary id for an optimistic entry, echoed back by the
+    // create response and never stored
+    ClientID    string `json:"client_id,omitempty" gorm:"-"`
 }
 
-var db *gorm.DB
+// Longest client_id echoed back on create
+const maxClientIDLength = 255
+
+// setDerivedFields fills in the read-only fields computed from the stored
+// ones, so every client gets the same answer
+func (t *Todo) setDerivedFields() {
+    // Server-relative download link, clients don't have to pick apart file_path
+    t.DownloadURL = ""
+    if t.FilePath != "" {
+        t.DownloadURL = "/api/files/download/" + url.PathEscape(storedName(t.FilePath))
+    }
+    t.IsOverdue = t.DueDate != nil && !t.Completed && t.DueDate.Before(time.Now())
+}
+
+func (t *Todo) AfterFind(tx *gorm.DB) error {
+    t.setDerivedFields()
+    return nil
+}
+
+func (t *Todo) AfterSave(tx *gorm.DB) error {
+    t.setDerivedFields()
+    return nil
+}
+
+// completedAt is the completed_at value to store alongside completed
+func completedAt(completed bool) *time.Time {
+    if !completed {
+        return nil
+    }
+    now := time.Now()
+    return &now
+}
+
+// completedAtUpdate is completedAt for bulk updates, keeping the original
+// timestamp on rows that were already in the requested state
+func completedAtUpdate(completed bool) clause.Expr {
+    return gorm.Expr("CASE WHEN completed = ? THEN completed_at ELSE ? END", completed, completedAt(completed))
+}
+
+// Slack for client clocks when rejecting due dates in the past
+const dueDateSkew = time.Minute
+
+// How many fresh UUIDs createTodo tries before giving up
+const maxUUIDAttempts = 3
+
+// Modes understood by libpq/pgx for sslmode
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// databaseDSN prefers a full DATABASE_URL and falls back to the DB_* variables
+func databaseDSN() (string, error) {
+    // libpq sslmode, defaulting to disable to match the bundled postgres
+    sslMode := os.Getenv("DB_SSLMODE")
+    if sslMode == "" {
+        sslMode = "disable"
+    }
+    if !contains(sslModes, sslMode) {
+        return "", fmt.Errorf("DB_SSLMODE must be one of %s, got %q", strings.Join(sslModes, ", "), sslMode)
+    }
+    sslRootCert := os.Getenv("DB_SSLROOTCERT")
+    if sslRootCert != "" {
+        if _, err := os.Stat(sslRootCert); err != nil {
+            return "", fmt.Errorf("DB_SSLROOTCERT: %v", err)
+        }
+    }
+
+    if databaseURL := os.Getenv("DATABASE_URL"); databaseURL != "" {
+        u, err := url.Parse(databaseURL)
+        if err != nil {
+            // url errors echo the input, which contains the password
+            return "", errors.New("DATABASE_URL is not a valid URL")
+        }
+        if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+            return "", fmt.Errorf("DATABASE_URL must use the postgres:// scheme, got %q", u.Scheme)
+        }
+        if u.Host == "" {
+            return "", fmt.Errorf("DATABASE_URL is missing a host: %s", u.Redacted())
+        }
+        if strings.Trim(u.Path, "/") == "" {
+            return "", fmt.Errorf("DATABASE_URL is missing a database name: %s", u.Redacted())
+        }
+
+        // Explicit DB_SSL* settings fill in whatever the URL leaves out
+        query := u.Query()
+        if query.Get("sslmode") == "" && os.Getenv("DB_SSLMODE") != "" {
+            query.Set("sslmode", sslMode)
+        }
+        if query.Get("sslrootcert") == "" && sslRootCert != "" {
+            query.Set("sslrootcert", sslRootCert)
+        }
+        u.RawQuery = query.Encode()
+        return u.String(), nil
+    }
+
+    dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+        os.Getenv("DB_HOST"),
+        os.Getenv("DB_USER"),
+        os.Getenv("DB_PASSWORD"),
+        os.Getenv("DB_NAME"),
+        os.Getenv("DB_PORT"),
+        sslMode,
+    )
+    if sslRootCert != "" {
+        dsn += " sslrootcert=" + sslRootCert
+    }
+    return dsn, nil
+}
+
+func connectToDatabase(config Config) *gorm.DB {
+    dsn, err := databaseDSN()
+    if err != nil {
+        log.Fatalf("Invalid database configuration: %v", err)
+    }
 
-func connectToDatabase() *gorm.DB {
     maxRetries := 5
     for attempt := 1; attempt <= maxRetries; attempt++ {
-        dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-            os.Getenv("DB_HOST"),
-            os.Getenv("DB_USER"),
-            os.Getenv("DB_PASSWORD"),
-            os.Getenv("DB_NAME"),
-            os.Getenv("DB_PORT"),
-        )
-
-        database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+        database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+            // Map driver errors such as unique violations to gorm.ErrDuplicatedKey
+            TranslateError: true,
+            // Slow queries and errors go through the app's log output
+            Logger: logger.New(log.Default(), logger.Config{
+                SlowThreshold:             config.SlowQueryThreshold,
+                LogLevel:                  logger.Warn,
+                IgnoreRecordNotFoundError: true,
+                // Parameters can hold todo content, keep it out of the logs
+                ParameterizedQueries:      config.LogRedactParams,
+                Colorful:                  false,
+            }),
+        })
         if err == nil {
             log.Println("Successfully connected to database")
             return database
@@ -53,217 +189,444 @@ func connectToDatabase() *gorm.DB {
     return nil
 }
 
-func main() {
-    // Retry database connection
-    db = connectToDatabase()
-
-    // Auto migrate the schema
-    err := db.AutoMigrate(&Todo{})
+// migrate brings the schema and the data it constrains up to date
+func migrate(db *gorm.DB, config Config) error {
+    err := db.AutoMigrate(&Todo{}, &Tag{}, &Template{}, &File{}, &Comment{}, &Draft{}, &Folder{}, &SavedView{}, &PartialUpload{}, &TrashedFile{}, &OwnershipTransfer{})
     if err != nil {
-        log.Fatalf("Failed to migrate database: %v", err)
+        return err
     }
-
-    // Ensure uploads directory exists
-    uploadDir := "/app/uploads"
-    if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
-        log.Fatalf("Failed to create uploads directory: %v", err)
+    if err := migrateFileAttachments(db); err != nil {
+        return err
     }
+    if err := normalizeExistingTags(db); err != nil {
+        return err
+    }
+    return enforceDescriptionLimit(db, config.MaxDescriptionBytes)
+}
 
-    // Create router
-    r := mux.NewRouter()
+func main() {
+    config, err := loadConfig()
+    if err != nil {
+        log.Fatal(err)
+    }
 
-    // Subrouter for "/api" prefix
-	api := r.PathPrefix("/api").Subrouter()
+    // Descriptions are sealed with FIELD_ENCRYPTION_KEY, or kept as plaintext
+    schema.RegisterSerializer("encrypted", encryptedSerializer{cipher: config.FieldCipher})
+    schema.RegisterSerializer("encryptedjson", encryptedJSONSerializer{cipher: config.FieldCipher})
 
-	// CRUD Routes for Todos
-	api.HandleFunc("/todos", createTodo).Methods("POST")
-	api.HandleFunc("/todos", getAllTodos).Methods("GET")
-	api.HandleFunc("/todos/{uuid}", getTodo).Methods("GET")
-	api.HandleFunc("/todos/{uuid}", updateTodo).Methods("PUT")
-	api.HandleFunc("/todos/{uuid}", deleteTodo).Methods("DELETE")
+    // Retry database connection
+    db := connectToDatabase(config)
 
-	// File system routes
-	api.HandleFunc("/files/upload", uploadFile).Methods("POST")
-	api.HandleFunc("/files/list", listFiles).Methods("GET")
-	api.HandleFunc("/files/download/{filename}", downloadFile).Methods("GET")
-	api.HandleFunc("/files/{filename}", deleteFile).Methods("DELETE")
+    // "main migrate" only migrates and exits, for running it as its own step
+    // with AUTO_MIGRATE=false on the server
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        if err := migrate(db, config); err != nil {
+            log.Fatalf("Failed to migrate database: %v", err)
+        }
+        log.Println("Database migrated")
+        return
+    }
+    if config.AutoMigrate {
+        if err := migrate(db, config); err != nil {
+            log.Fatalf("Failed to migrate database: %v", err)
+        }
+    } else {
+        log.Println("AUTO_MIGRATE is off, skipping database migrations")
+    }
 
+    // Local uploads directory or S3-compatible bucket, per STORAGE_BACKEND
+    storage, err := newStorage()
+    if err != nil {
+        log.Fatalf("Failed to set up file storage: %v", err)
+    }
 
-    // CORS and server setup
-    // handler := cors.Default().Handler(r)
-	// allow all origins and headers
-	handler := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders: []string{"Content-Type"},
-	}).Handler(r)
+    server := NewServer(db, storage, config)
+    if config.CompletedRetention > 0 {
+        server.startWorker("retention", time.Hour, server.runRetention, server.retentionPending)
+    }
+    if config.FileTrashRetention > 0 {
+        server.startWorker("file-trash", time.Hour, server.purgeTrash, server.trashPending)
+    }
+    server.startWorker("partial-uploads", time.Hour, server.expirePartialUploads, server.partialUploadsPending)
     log.Println("Server starting on :8080")
-    if err := http.ListenAndServe(":8080", handler); err != nil {
+    if err := http.ListenAndServe(":8080", server.routes()); err != nil {
         log.Fatalf("Failed to start server: %v", err)
     }
 }
 
-func createTodo(w http.ResponseWriter, r *http.Request) {
+// rejectsDueDate applies REJECT_PAST_DUE_DATES to a todo being created
+func (s *Server) rejectsDueDate(r *http.Request, dueDate *time.Time) bool {
+    if dueDate == nil || !s.config.RejectPastDueDates || r.URL.Query().Get("allow_past") == "true" {
+        return false
+    }
+    return dueDate.Before(time.Now().Add(-dueDateSkew))
+}
+
+// validateNewTodo applies the rules shared by every way of creating a todo
+// and returns its cleaned tag names. Errors are meant for a 400.
+func (s *Server) validateNewTodo(r *http.Request, todo *Todo) ([]string, error) {
+    if s.rejectsDueDate(r, todo.DueDate) {
+        return nil, errors.New("due_date is in the past, pass ?allow_past=true to backdate")
+    }
+    if err := s.checkDescription(todo.Description); err != nil {
+        return nil, err
+    }
+    if len(todo.ClientID) > maxClientIDLength {
+        return nil, fmt.Errorf("client_id must be at most %d bytes", maxClientIDLength)
+    }
+    return cleanTagNames(tagNames(todo.Tags))
+}
+
+func (s *Server) createTodo(w http.ResponseWriter, r *http.Request) {
     var todo Todo
-    err := json.NewDecoder(r.Body).Decode(&todo)
+    err := decodeJSON(r, &todo)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        writeError(w, http.StatusBadRequest, err.Error())
         return
     }
 
-    // Generate a unique UUID for the todo
-    todo.UUID = uuid.New().String()
+    // Tags are attached after the insert so existing tag rows get reused
+    tags, err := s.validateNewTodo(r, &todo)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    todo.Tags = nil
+
+    todo.CompletedAt = completedAt(todo.Completed)
+    todo.OwnerID = ""
+
+    var result *gorm.DB
+    if todo.UUID != "" {
+        // Honour a client-supplied UUID (offline-first clients create ids locally)
+        parsed, err := uuid.Parse(todo.UUID)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, "uuid must be a valid UUID")
+            return
+        }
+        todo.UUID = parsed.String()
 
-    result := db.Create(&todo)
+        result = s.db.Create(&todo)
+        if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+            writeError(w, http.StatusConflict, "a todo with this uuid already exists")
+            return
+        }
+    } else {
+        // Generate a unique UUID for the todo, retrying on the rare collision
+        for attempt := 1; attempt <= maxUUIDAttempts; attempt++ {
+            todo.UUID = s.newTodoUUID()
+            result = s.db.Create(&todo)
+            if !errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+                break
+            }
+            log.Printf("UUID collision on create (attempt %d/%d)", attempt, maxUUIDAttempts)
+        }
+        if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+            writeError(w, http.StatusInternalServerError, "could not allocate a unique id, please retry")
+            return
+        }
+    }
     if result.Error != nil {
-        http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+        writeError(w, http.StatusInternalServerError, result.Error.Error())
         return
     }
+    if len(tags) > 0 {
+        if err := setTodoTags(s.db, &todo, tags); err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+    }
 
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(todo)
 }
 
-func getAllTodos(w http.ResponseWriter, r *http.Request) {
-    var todos []Todo
-    result := db.Find(&todos)
-    if result.Error != nil {
-        http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+func (s *Server) getAllTodos(w http.ResponseWriter, r *http.Request) {
+    // ?view=<name> fills in the saved preset's parameters
+    r, err := s.applySavedView(r)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "view not found")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(todos)
-}
-
-func getTodo(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    uuid := vars["uuid"]
+    filters, err := s.parseTodoFilters(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
 
-    var todo Todo
-    result := db.Where("uuid = ?", uuid).First(&todo)
-    if result.Error != nil {
-        http.Error(w, result.Error.Error(), http.StatusNotFound)
+    orderBy, err := todoOrder(r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
         return
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(todo)
-}
+    fields, err := parseFields(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
 
-func updateTodo(w http.ResponseWriter, r *http.Request) {
-    vars := mux.Vars(r)
-    uuid := vars["uuid"]
+    page, err := s.parsePage(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
 
-    var updatedTodo Todo
-    err := json.NewDecoder(r.Body).Decode(&updatedTodo)
+    // Initialized so an empty result is [] rather than null
+    todos := []Todo{}
+    var total int64
+    err = s.withReadRetry(func() error {
+        if page == nil {
+            return s.db.Preload("Tags").Scopes(filters).Order(orderBy).Find(&todos).Error
+        }
+        if err := s.db.Model(&Todo{}).Scopes(filters).Count(&total).Error; err != nil {
+            return err
+        }
+        return s.db.Preload("Tags").Scopes(filters).Order(orderBy).Offset(page.Offset()).Limit(page.Size).Find(&todos).Error
+    })
     if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
 
-    result := db.Model(&Todo{}).Where("uuid = ?", uuid).Updates(map[string]interface{}{
-        "completed": updatedTodo.Completed,
-    })    
-    if result.Error != nil {
-        http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+    // Pollers send back the ETag and get 304 while nothing has changed. It
+    // comes from the rows already loaded, so it costs no extra query.
+    etag := todoListETag(r, todos, total)
+    w.Header().Set("ETag", etag)
+    w.Header().Add("Vary", "Accept")
+    if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+        w.WriteHeader(http.StatusNotModified)
         return
     }
 
-    var todo Todo
-    db.Where("uuid = ?", uuid).First(&todo)
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(todo)
+    if wantsJSONAPI(r) {
+        resources := make([]jsonAPIResource, len(todos))
+        for i, todo := range todos {
+            if resources[i], err = todoResource(todo, fields); err != nil {
+                writeError(w, http.StatusInternalServerError, err.Error())
+                return
+            }
+        }
+        document := map[string]interface{}{"data": resources}
+        if page != nil {
+            meta := s.pageMeta(page, total)
+            document["meta"] = meta
+            document["links"] = pageLinks(r, meta)
+        }
+        writeJSONAPI(w, http.StatusOK, document)
+        return
+    }
+
+    var items interface{} = todos
+    if fields != nil {
+        sparse := make([]map[string]json.RawMessage, len(todos))
+        for i, todo := range todos {
+            if sparse[i], err = selectFields(todo, fields); err != nil {
+                writeError(w, http.StatusInternalServerError, err.Error())
+                return
+            }
+        }
+        items = sparse
+    }
+
+    // Paginated requests get an envelope, plain requests keep the bare array
+    if page != nil {
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+            "todos": items,
+            "meta":  s.pageMeta(page, total),
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, items)
 }
 
-func deleteTodo(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getTodo(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     uuid := vars["uuid"]
 
-    result := db.Where("uuid = ?", uuid).Delete(&Todo{})
-    if result.Error != nil {
-        http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+    fields, err := parseFields(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
         return
     }
 
-    w.WriteHeader(http.StatusNoContent)
-}
+    withAttachments := false
+    if include := r.URL.Query().Get("include"); include != "" {
+        if include != "attachments" {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid include %q, must be attachments", include))
+            return
+        }
+        withAttachments = true
+    }
 
-func uploadFile(w http.ResponseWriter, r *http.Request) {
-    file, header, err := r.FormFile("file")
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+    var todo Todo
+    err = s.withReadRetry(func() error {
+        query := s.db.Preload("Tags")
+        if withAttachments {
+            query = query.Preload("Attachments", func(tx *gorm.DB) *gorm.DB {
+                return tx.Order("id")
+            })
+        }
+        return query.Where("uuid = ?", uuid).First(&todo).Error
+    })
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "todo not found")
         return
     }
-    defer file.Close()
-
-    uploadDir := "/app/uploads"
-    filePath := filepath.Join(uploadDir, fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(header.Filename)))
-    outFile, err := os.Create(filePath)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
-    defer outFile.Close()
 
-    _, err = io.Copy(outFile, file)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    if wantsJSONAPI(r) {
+        resource, err := todoResource(todo, fields)
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        writeJSONAPI(w, http.StatusOK, map[string]interface{}{"data": resource})
         return
     }
 
     w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(http.StatusCreated)
-    json.NewEncoder(w).Encode(map[string]string{"file_path": filePath})
-}
+    if fields == nil {
+        if withAttachments {
+            // Always include the array when asked for, even if it is empty
+            json.NewEncoder(w).Encode(struct {
+                Todo
+                Attachments []File `json:"attachments"`
+            }{todo, append([]File{}, todo.Attachments...)})
+            return
+        }
+        json.NewEncoder(w).Encode(todo)
+        return
+    }
 
-func listFiles(w http.ResponseWriter, r *http.Request) {
-    uploadDir := "/app/uploads"
-    files, err := os.ReadDir(uploadDir)
+    sparse, err := selectFields(todo, fields)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
+    json.NewEncoder(w).Encode(sparse)
+}
 
-    var fileNames []string
-    for _, file := range files {
-        if !file.IsDir() {
-            fileNames = append(fileNames, file.Name())
+// insertTodoIfMissing creates todo unless its UUID is already taken, in one
+// atomic INSERT ... ON CONFLICT DO NOTHING. It reports whether it inserted.
+func (s *Server) insertTodoIfMissing(todo *Todo) (bool, error) {
+    todo.Model = Model{}
+    todo.CompletedAt = completedAt(todo.Completed)
+    tags := tagNames(todo.Tags)
+    todo.Tags = nil
+
+    result := s.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "uuid"}}, DoNothing: true}).Create(todo)
+    if result.Error != nil || result.RowsAffected == 0 {
+        return false, result.Error
+    }
+    if len(tags) > 0 {
+        if err := setTodoTags(s.db, todo, tags); err != nil {
+            return true, err
         }
     }
-
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(fileNames)
+    return true, nil
 }
 
-func downloadFile(w http.ResponseWriter, r *http.Request) {
+// updateTodo is an upsert: a todo with an unknown UUID is created from the
+// body (201), so offline clients can push local state without checking first
+func (s *Server) updateTodo(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
-    fileName := vars["filename"]
-    filePath := filepath.Join("/app/uploads", fileName)
+    todoUUID := vars["uuid"]
+
+    var updatedTodo Todo
+    err := decodeJSON(r, &updatedTodo)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
 
-    file, err := os.Open(filePath)
+    if parsed, err := uuid.Parse(todoUUID); err == nil {
+        var exists int64
+        if err := s.db.Model(&Todo{}).Where("uuid = ?", parsed.String()).Count(&exists).Error; err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        // The create rules only apply when this turns out to be a create, an
+        // update ignores everything but completed
+        if exists == 0 {
+            if _, err := s.validateNewTodo(r, &updatedTodo); err != nil {
+                writeError(w, http.StatusBadRequest, err.Error())
+                return
+            }
+            created := updatedTodo
+            created.UUID = parsed.String()
+            created.OwnerID = ""
+            inserted, err := s.insertTodoIfMissing(&created)
+            if err != nil {
+                writeError(w, http.StatusInternalServerError, err.Error())
+                return
+            }
+            if inserted {
+                writeJSON(w, http.StatusCreated, created)
+                return
+            }
+        }
+    }
+
+    var todo Todo
+    err = s.db.Preload("Tags").Where("uuid = ?", todoUUID).First(&todo).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "todo not found")
+        return
+    }
     if err != nil {
-        http.Error(w, "File not found", http.StatusNotFound)
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    // Only write the fields that differ, so a no-op update leaves updated_at alone
+    changes := map[string]interface{}{}
+    if updatedTodo.Completed != todo.Completed {
+        changes["completed"] = updatedTodo.Completed
+        changes["completed_at"] = completedAt(updatedTodo.Completed)
+    }
+    if len(changes) == 0 {
+        w.Header().Set("X-Todo-Unchanged", "true")
+        writeJSON(w, http.StatusOK, todo)
         return
     }
-    defer file.Close()
 
-    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
-    w.Header().Set("Content-Type", "application/octet-stream")
-    io.Copy(w, file)
+    if err := s.db.Model(&todo).Updates(changes).Error; err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    todo.setDerivedFields()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(todo)
 }
 
-func deleteFile(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteTodo(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
-    fileName := vars["filename"]
-    filePath := filepath.Join("/app/uploads", fileName)
+    uuid := vars["uuid"]
 
-    err := os.Remove(filePath)
+    // Comments and drafts go with their todo
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("uuid = ?", uuid).Delete(&Todo{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("todo_uuid = ?", uuid).Delete(&Comment{}).Error; err != nil {
+            return err
+        }
+        return tx.Where("todo_uuid = ?", uuid).Delete(&Draft{}).Error
+    })
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, http.StatusInternalServerError, err.Error())
         return
     }
 
-    w.WriteHeader(http.StatusOK)
-}
\ No newline at end of file
+    w.WriteHeader(http.StatusNoContent)
+}
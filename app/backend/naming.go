@@ -0,0 +1,105 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Strategies for naming stored uploads, picked with FILE_NAMING:
+//   timestamp  <unixnano>-<original name>
+//   uuid       <random uuid><extension>
+//   hash       <sha256 of the content><extension>, identical uploads share a file
+var fileNamingStrategies = []string{"timestamp", "uuid", "hash"}
+
+// Length of a hex encoded SHA-256, the stem of hash strategy names
+const sha256HexLength = 64
+
+// safeFileName keeps letters, digits, dots, dashes and underscores and
+// replaces anything else, so stored names are safe in paths, URLs and headers.
+func safeFileName(name string) string {
+    name = filepath.Base(name)
+    safe := strings.Map(func(c rune) rune {
+        switch {
+        case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+            return c
+        default:
+            return '_'
+        }
+    }, name)
+    safe = strings.TrimLeft(safe, ".")
+    if safe == "" {
+        safe = "file"
+    }
+    if len(safe) > 200 {
+        safe = safe[len(safe)-200:]
+    }
+    return safe
+}
+
+// safeExt is the lower-cased extension of name, or "" unless it is short
+// and alphanumeric
+func safeExt(name string) string {
+    ext := strings.ToLower(filepath.Ext(name))
+    if len(ext) < 2 || len(ext) > 16 {
+        return ""
+    }
+    for _, c := range ext[1:] {
+        if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+            return ""
+        }
+    }
+    return ext
+}
+
+// isHashName reports whether name was given by the hash strategy, whose
+// name must always match the content
+func isHashName(name string) bool {
+    if len(name) < sha256HexLength || safeExt(name[sha256HexLength:]) != name[sha256HexLength:] {
+        return false
+    }
+    for _, c := range name[:sha256HexLength] {
+        if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+            return false
+        }
+    }
+    return true
+}
+
+// storeUpload saves an upload under a name from the configured strategy and
+// returns its metadata, not yet recorded. reused is set when the hash strategy
+// found the same content already stored, in which case nothing new was saved.
+func (s *Server) storeUpload(original string, content io.ReadSeeker, size int64) (record File, reused bool, err error) {
+    record = File{Size: size, ContentType: detectContentType(original, content)}
+
+    switch s.config.FileNaming {
+    case "uuid":
+        record.Name = uuid.New().String() + safeExt(original)
+    case "hash":
+        tempName := fmt.Sprintf(".upload-%d", time.Now().UnixNano())
+        if record.SHA256, err = s.saveHashed(tempName, content, size); err != nil {
+            return File{}, false, err
+        }
+        record.Name = record.SHA256 + safeExt(original)
+        if _, err := s.storage.Stat(record.Name); err == nil {
+            s.storage.Delete(tempName)
+            return record, true, nil
+        }
+        if err := s.storage.Rename(tempName, record.Name); err != nil {
+            s.storage.Delete(tempName)
+            return File{}, false, err
+        }
+        return record, false, nil
+    default:
+        record.Name = fmt.Sprintf("%d-%s", time.Now().UnixNano(), safeFileName(original))
+    }
+
+    if record.SHA256, err = s.saveHashed(record.Name, content, size); err != nil {
+        return File{}, false, err
+    }
+    return record, false, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+
+    "github.com/gorilla/mux"
+)
+
+// describeResource answers OPTIONS with the methods the path currently
+// supports, so generic clients can adapt. Writes are left out while the
+// server is read-only.
+func (s *Server) describeResource(router *mux.Router, resource, description string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        readOnly := s.readOnly.Load()
+        var methods []string
+        for _, method := range allowedMethods(router, r.URL.Path) {
+            switch method {
+            case http.MethodGet, http.MethodHead, http.MethodOptions:
+            default:
+                if readOnly {
+                    continue
+                }
+            }
+            methods = append(methods, method)
+        }
+
+        w.Header().Set("Allow", strings.Join(methods, ", "))
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+            "resource":    resource,
+            "description": description,
+            "methods":     methods,
+            "read_only":   readOnly,
+        })
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+// Page is the ?page=&page_size= a client asked for, with the size already
+// clamped to the configured maximum.
+type Page struct {
+    Number  int
+    Size    int
+    Clamped bool
+}
+
+// PageMeta describes a page of results in the list envelope
+type PageMeta struct {
+    Page            int   `json:"page"`
+    PageSize        int   `json:"page_size"`
+    Total           int64 `json:"total"`
+    TotalPages      int64 `json:"total_pages"`
+    DefaultPageSize int   `json:"default_page_size"`
+    MaxPageSize     int   `json:"max_page_size"`
+    // Set when the requested page_size was above max_page_size
+    Clamped bool `json:"clamped"`
+}
+
+// parsePage returns nil when the request has neither page nor page_size, so
+// unpaginated clients keep getting a plain array.
+func (s *Server) parsePage(r *http.Request) (*Page, error) {
+    query := r.URL.Query()
+    if !query.Has("page") && !query.Has("page_size") {
+        return nil, nil
+    }
+
+    page := &Page{Number: 1, Size: s.config.DefaultPageSize}
+    if value := query.Get("page"); value != "" {
+        number, err := strconv.Atoi(value)
+        if err != nil || number < 1 {
+            return nil, fmt.Errorf("invalid page %q, must be a positive integer", value)
+        }
+        page.Number = number
+    }
+    if value := query.Get("page_size"); value != "" {
+        size, err := strconv.Atoi(value)
+        if err != nil || size < 1 {
+            return nil, fmt.Errorf("invalid page_size %q, must be a positive integer", value)
+        }
+        page.Size = size
+    }
+    if page.Size > s.config.MaxPageSize {
+        page.Size = s.config.MaxPageSize
+        page.Clamped = true
+    }
+    return page, nil
+}
+
+func (p *Page) Offset() int {
+    return (p.Number - 1) * p.Size
+}
+
+func (s *Server) pageMeta(page *Page, total int64) PageMeta {
+    return PageMeta{
+        Page:            page.Number,
+        PageSize:        page.Size,
+        Total:           total,
+        TotalPages:      (total + int64(page.Size) - 1) / int64(page.Size),
+        DefaultPageSize: s.config.DefaultPageSize,
+        MaxPageSize:     s.config.MaxPageSize,
+        Clamped:         page.Clamped,
+    }
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+    "net/http/pprof"
+
+    "github.com/gorilla/mux"
+)
+
+// registerPprof exposes the runtime profiler under /debug/pprof/. It is only
+// called when ENABLE_PPROF=true since profiles leak internals.
+func registerPprof(r *mux.Router) {
+    r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    // Index also serves the named profiles (heap, goroutine, allocs, ...)
+    r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+}
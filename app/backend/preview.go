@@ -0,0 +1,119 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "net/http"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+
+    "github.com/gorilla/mux"
+)
+
+const (
+    defaultPreviewBytes = 1024
+    maxPreviewBytes     = 64 << 10
+)
+
+// isTextContent decides whether a preview is safe to show as text: a text or
+// text-like media type, or content that is valid UTF-8 without NUL bytes
+func isTextContent(contentType string, head []byte) bool {
+    mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+    switch {
+    case strings.HasPrefix(mediaType, "text/"),
+        mediaType == "application/json",
+        mediaType == "application/xml",
+        mediaType == "application/javascript",
+        strings.HasSuffix(mediaType, "+json"),
+        strings.HasSuffix(mediaType, "+xml"):
+        return true
+    case mediaType != "" && mediaType != "application/octet-stream":
+        return false
+    }
+    return bytes.IndexByte(head, 0) < 0 && utf8.Valid(trimPartialRune(head))
+}
+
+// trimPartialRune drops a UTF-8 sequence cut off at the end of b
+func trimPartialRune(b []byte) []byte {
+    for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+        if utf8.RuneStart(b[len(b)-i]) {
+            if !utf8.FullRune(b[len(b)-i:]) {
+                return b[:len(b)-i]
+            }
+            break
+        }
+    }
+    return b
+}
+
+// previewFile returns the first ?bytes= (default 1024, at most 64 KiB) of a
+// text file as a string, so clients can show a snippet without downloading
+// the whole file. Binary files get 415.
+func (s *Server) previewFile(w http.ResponseWriter, r *http.Request) {
+    fileName := mux.Vars(r)["filename"]
+
+    limit := defaultPreviewBytes
+    if value := r.URL.Query().Get("bytes"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n <= 0 {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid bytes %q, must be a positive integer", value))
+            return
+        }
+        if n > maxPreviewBytes {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("bytes must be at most %d", maxPreviewBytes))
+            return
+        }
+        limit = n
+    }
+
+    info, err := s.statFile(fileName)
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+
+    file, err := s.storage.Open(fileName)
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "File not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    defer file.Close()
+
+    head, err := io.ReadAll(io.LimitReader(file, int64(limit)))
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+
+    contentType := http.DetectContentType(head)
+    var record File
+    if err := s.db.Select("content_type").Where("name = ?", fileName).First(&record).Error; err == nil && record.ContentType != "" {
+        contentType = record.ContentType
+    }
+    if !isTextContent(contentType, head) {
+        writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("%s files can't be previewed as text", contentType))
+        return
+    }
+
+    content := trimPartialRune(head)
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "name":         fileName,
+        "content_type": contentType,
+        "size":         info.Size,
+        "bytes":        len(content),
+        "truncated":    int64(len(content)) < info.Size,
+        "content":      string(content),
+    })
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// quotaExceeded is the body of every response for a hit rate limit or quota,
+// so clients can tell which limit it was and when to try again
+type quotaExceeded struct {
+    Error     string `json:"error"`
+    LimitType string `json:"limit_type"`
+    Limit     int64  `json:"limit"`
+    Current   int64  `json:"current"`
+    // nil for quotas that only free up when something is deleted
+    ResetAt *time.Time `json:"reset_at"`
+    Message string     `json:"message"`
+}
+
+// writeQuotaExceeded answers with the quota body. Rate limits use 429 with
+// Retry-After; the storage quota keeps its 507 as it never resets by itself.
+func writeQuotaExceeded(w http.ResponseWriter, status int, limitType string, limit, current int64, resetAt *time.Time, message string) {
+    if resetAt != nil {
+        seconds := int(time.Until(*resetAt).Round(time.Second) / time.Second)
+        if seconds < 1 {
+            seconds = 1
+        }
+        w.Header().Set("Retry-After", strconv.Itoa(seconds))
+    }
+    writeJSON(w, status, quotaExceeded{
+        Error:     "quota_exceeded",
+        LimitType: limitType,
+        Limit:     limit,
+        Current:   current,
+        ResetAt:   resetAt,
+        Message:   message,
+    })
+}
+
+// ownerStorageUsage sums the files attached to an owner's live todos, leaving
+// out the file named except, which an overwrite is about to replace
+func (s *Server) ownerStorageUsage(owner, except string) (int64, error) {
+    var used int64
+    // A file attached to several of the owner's todos counts once
+    owned := s.db.Table("file_attachments").
+        Select("file_attachments.file_id").
+        Joins("JOIN todos ON todos.id = file_attachments.todo_id AND todos.deleted_at IS NULL").
+        Where("todos.owner_id = ?", owner)
+    err := s.db.Model(&File{}).
+        Where("id IN (?) AND name <> ?", owned, except).
+        Select("COALESCE(SUM(size), 0)").
+        Scan(&used).Error
+    return used, err
+}
+
+// checkOwnerQuota applies PER_OWNER_STORAGE_BYTES to an upload of size bytes.
+// The owner is the one of the todo it is attached to, or for an overwrite
+// without a todo, of the todo the replaced file belongs to. Uploads that end
+// up with no owner are only bound by MAX_UPLOAD_DIR_BYTES. It reports false
+// after answering the request itself.
+func (s *Server) checkOwnerQuota(w http.ResponseWriter, todoID *jsonID, replacing string, size int64) bool {
+    limit := s.config.PerOwnerStorageBytes
+    if limit <= 0 {
+        return true
+    }
+
+    var owners []string
+    var err error
+    switch {
+    case todoID != nil:
+        err = s.db.Model(&Todo{}).Where("id = ?", *todoID).Pluck("owner_id", &owners).Error
+    case replacing != "":
+        err = s.db.Model(&File{}).
+            Joins("JOIN file_attachments ON file_attachments.file_id = files.id").
+            Joins("JOIN todos ON todos.id = file_attachments.todo_id AND todos.deleted_at IS NULL").
+            Where("files.name = ?", replacing).
+            Order("todos.id").
+            Pluck("todos.owner_id", &owners).Error
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return false
+    }
+    if len(owners) == 0 || owners[0] == "" {
+        return true
+    }
+
+    used, err := s.ownerStorageUsage(owners[0], replacing)
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return false
+    }
+    if used+size > limit {
+        writeQuotaExceeded(w, http.StatusInsufficientStorage, "owner_storage", limit, used, nil,
+            fmt.Sprintf("upload would exceed owner %s's storage limit (%d of %d bytes used)", owners[0], used, limit))
+        return false
+    }
+    return true
+}
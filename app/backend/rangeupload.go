@@ -0,0 +1,326 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// PartialUpload is a file being uploaded in parts with Content-Range. The
+// bytes are staged in PARTIAL_UPLOAD_DIR, preallocated to the full size, and
+// only go to storage once every byte has arrived. Parts must arrive in order,
+// so Received is both the byte count and the offset of the next part.
+type PartialUpload struct {
+    ID        string    `json:"id" gorm:"primaryKey"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    Name      string    `json:"name"`
+    Size      int64     `json:"size"`
+    Received  int64     `json:"received"`
+    TodoID    *jsonID   `json:"-"`
+}
+
+func (s *Server) partialPath(id string) string {
+    return filepath.Join(s.config.PartialUploadDir, id)
+}
+
+// Content-Range: bytes <first>-<last>/<size>, or bytes */<size> to ask for the
+// current state without sending data
+var contentRangePattern = regexp.MustCompile(`^bytes (?:(\d+)-(\d+)|\*)/(\d+)$`)
+
+// parseContentRange returns the first and last byte of the part, both -1 for
+// a status query
+func parseContentRange(value string) (first, last, size int64, err error) {
+    match := contentRangePattern.FindStringSubmatch(strings.TrimSpace(value))
+    if match == nil {
+        return 0, 0, 0, fmt.Errorf("invalid Content-Range %q, must be bytes <first>-<last>/<size> or bytes */<size>", value)
+    }
+    if size, err = strconv.ParseInt(match[3], 10, 64); err != nil {
+        return 0, 0, 0, fmt.Errorf("invalid Content-Range size %q", match[3])
+    }
+    if match[1] == "" {
+        return -1, -1, size, nil
+    }
+    first, err1 := strconv.ParseInt(match[1], 10, 64)
+    last, err2 := strconv.ParseInt(match[2], 10, 64)
+    if err1 != nil || err2 != nil || last < first {
+        return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", value)
+    }
+    return first, last, size, nil
+}
+
+// writeUploadProgress answers with the upload's state and a Range header for
+// the bytes received so far, which is where a client resumes from
+func writeUploadProgress(w http.ResponseWriter, status int, upload PartialUpload) {
+    if upload.Received > 0 {
+        w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", upload.Received-1))
+    }
+    writeJSON(w, status, upload)
+}
+
+// createPartialUpload starts a ranged upload of {"name", "size", "todo"}
+func (s *Server) createPartialUpload(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        Name string `json:"name"`
+        Size int64  `json:"size"`
+        Todo string `json:"todo"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if strings.TrimSpace(body.Name) == "" {
+        writeError(w, http.StatusBadRequest, "name is required")
+        return
+    }
+    if body.Size <= 0 {
+        writeError(w, http.StatusBadRequest, "size must be positive")
+        return
+    }
+
+    upload := PartialUpload{ID: uuid.New().String(), Name: body.Name, Size: body.Size}
+    if body.Todo != "" {
+        var todo Todo
+        err := s.db.Select("id").Where("uuid = ?", body.Todo).First(&todo).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            writeError(w, http.StatusNotFound, "todo not found")
+            return
+        }
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        upload.TodoID = &todo.ID
+    }
+
+    if s.config.MaxUploadDirBytes > 0 {
+        used, err := s.storageUsage()
+        if err != nil {
+            writeStorageError(w, err)
+            return
+        }
+        if used+body.Size > s.config.MaxUploadDirBytes {
+            writeQuotaExceeded(w, http.StatusInsufficientStorage, "storage", s.config.MaxUploadDirBytes, used, nil,
+                fmt.Sprintf("upload would exceed the storage limit (%d of %d bytes used)", used, s.config.MaxUploadDirBytes))
+            return
+        }
+    }
+    if !s.checkOwnerQuota(w, upload.TodoID, "", body.Size) {
+        return
+    }
+
+    if err := os.MkdirAll(s.config.PartialUploadDir, os.ModePerm); err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    staged, err := os.Create(s.partialPath(upload.ID))
+    if err == nil {
+        err = staged.Truncate(upload.Size)
+        if closeErr := staged.Close(); err == nil {
+            err = closeErr
+        }
+    }
+    if err != nil {
+        os.Remove(s.partialPath(upload.ID))
+        writeStorageError(w, err)
+        return
+    }
+
+    if err := s.db.Create(&upload).Error; err != nil {
+        os.Remove(s.partialPath(upload.ID))
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    w.Header().Set("Location", "/api/files/uploads/"+upload.ID)
+    writeJSON(w, http.StatusCreated, upload)
+}
+
+func (s *Server) findPartialUpload(w http.ResponseWriter, id string) (*PartialUpload, bool) {
+    var upload PartialUpload
+    err := s.db.Where("id = ?", id).First(&upload).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "upload not found")
+        return nil, false
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return nil, false
+    }
+    return &upload, true
+}
+
+func (s *Server) getPartialUpload(w http.ResponseWriter, r *http.Request) {
+    upload, ok := s.findPartialUpload(w, mux.Vars(r)["id"])
+    if !ok {
+        return
+    }
+    writeUploadProgress(w, http.StatusOK, *upload)
+}
+
+var (
+    // Parts that would leave a gap or rewrite bytes already received
+    errPartOutOfOrder      = errors.New("part does not start at the next expected byte")
+    errRangeNotSatisfiable = errors.New("range not satisfiable")
+    errBadPart             = errors.New("invalid part")
+)
+
+// uploadPart writes the body at the offset given by Content-Range. Once the
+// last byte is in, the file is moved to storage and recorded like a regular
+// upload. A bytes */<size> request reports progress, and finishes an upload
+// whose final step failed earlier.
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request) {
+    first, last, size, err := parseContentRange(r.Header.Get("Content-Range"))
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    var upload PartialUpload
+    var missing bool
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        // Locked so two parts for the same upload can't be written at once
+        err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", mux.Vars(r)["id"]).First(&upload).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            missing = true
+            return nil
+        }
+        if err != nil || first < 0 {
+            return err
+        }
+        if size != upload.Size || last >= upload.Size {
+            return fmt.Errorf("%w: range %d-%d/%d is outside the upload's %d bytes", errRangeNotSatisfiable, first, last, size, upload.Size)
+        }
+        if first != upload.Received {
+            return errPartOutOfOrder
+        }
+
+        length := last - first + 1
+        if r.ContentLength >= 0 && r.ContentLength != length {
+            return fmt.Errorf("%w: body is %d bytes but Content-Range covers %d", errBadPart, r.ContentLength, length)
+        }
+        staged, err := os.OpenFile(s.partialPath(upload.ID), os.O_WRONLY, 0)
+        if err != nil {
+            return err
+        }
+        written, err := io.Copy(io.NewOffsetWriter(staged, first), io.LimitReader(r.Body, length))
+        if closeErr := staged.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            return err
+        }
+        if written != length {
+            return fmt.Errorf("%w: body ended after %d of %d bytes", errBadPart, written, length)
+        }
+
+        upload.Received = last + 1
+        return tx.Model(&upload).Update("received", upload.Received).Error
+    })
+    switch {
+    case missing:
+        writeError(w, http.StatusNotFound, "upload not found")
+        return
+    case errors.Is(err, errPartOutOfOrder):
+        if upload.Received > 0 {
+            w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", upload.Received-1))
+        }
+        writeJSON(w, http.StatusConflict, map[string]interface{}{
+            "error":    fmt.Sprintf("part must start at byte %d", upload.Received),
+            "received": upload.Received,
+        })
+        return
+    case errors.Is(err, errRangeNotSatisfiable):
+        w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", upload.Size))
+        writeError(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+        return
+    case errors.Is(err, errBadPart):
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    case err != nil:
+        writeStorageError(w, err)
+        return
+    }
+
+    if upload.Received < upload.Size {
+        writeUploadProgress(w, http.StatusOK, upload)
+        return
+    }
+    s.finishPartialUpload(w, upload)
+}
+
+// finishPartialUpload moves a fully received upload into storage
+func (s *Server) finishPartialUpload(w http.ResponseWriter, upload PartialUpload) {
+    staged, err := os.Open(s.partialPath(upload.ID))
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    defer staged.Close()
+
+    record, reused, err := s.storeUpload(upload.Name, staged, upload.Size)
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    if err := s.db.Delete(&upload).Error; err != nil {
+        log.Printf("Failed to delete finished upload %s: %v", upload.ID, err)
+    }
+    os.Remove(s.partialPath(upload.ID))
+    s.recordUpload(w, record, upload.TodoID, reused)
+}
+
+func (s *Server) abortPartialUpload(w http.ResponseWriter, r *http.Request) {
+    result := s.db.Where("id = ?", mux.Vars(r)["id"]).Delete(&PartialUpload{})
+    if result.Error != nil {
+        writeError(w, http.StatusInternalServerError, result.Error.Error())
+        return
+    }
+    if result.RowsAffected == 0 {
+        writeError(w, http.StatusNotFound, "upload not found")
+        return
+    }
+    os.Remove(s.partialPath(mux.Vars(r)["id"]))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// partialUploadsPending counts the uploads the next pass would expire
+func (s *Server) partialUploadsPending() (int64, error) {
+    var count int64
+    cutoff := time.Now().Add(-s.config.PartialUploadTTL)
+    err := s.db.Model(&PartialUpload{}).Where("updated_at < ?", cutoff).Count(&count).Error
+    return count, err
+}
+
+// expirePartialUploads drops uploads that haven't received a part within
+// PARTIAL_UPLOAD_TTL_HOURS, along with their staged bytes
+func (s *Server) expirePartialUploads() error {
+    var stale []PartialUpload
+    cutoff := time.Now().Add(-s.config.PartialUploadTTL)
+    if err := s.db.Where("updated_at < ?", cutoff).Find(&stale).Error; err != nil {
+        return err
+    }
+    for _, upload := range stale {
+        if err := s.db.Delete(&upload).Error; err != nil {
+            return err
+        }
+        os.Remove(s.partialPath(upload.ID))
+    }
+    if len(stale) > 0 {
+        log.Printf("Partial uploads: expired %d unfinished uploads", len(stale))
+    }
+    return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// rateLimiter allows a fixed number of requests per key in each window
+type rateLimiter struct {
+    limit  int
+    window time.Duration
+
+    mu      sync.Mutex
+    buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+    start time.Time
+    count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+    return &rateLimiter{limit: limit, window: window, buckets: make(map[string]*rateBucket)}
+}
+
+// allow records a request for key. It also returns the requests counted in
+// the key's window and when that window resets.
+func (l *rateLimiter) allow(key string) (bool, int, time.Time) {
+    now := time.Now()
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    bucket, ok := l.buckets[key]
+    if !ok || now.Sub(bucket.start) >= l.window {
+        // Drop expired buckets so idle clients don't accumulate
+        for k, b := range l.buckets {
+            if now.Sub(b.start) >= l.window {
+                delete(l.buckets, k)
+            }
+        }
+        bucket = &rateBucket{start: now}
+        l.buckets[key] = bucket
+    }
+
+    resetAt := bucket.start.Add(l.window)
+    if bucket.count >= l.limit {
+        return false, bucket.count, resetAt
+    }
+    bucket.count++
+    return true, bucket.count, resetAt
+}
+
+// limitUploads applies UPLOAD_RATE_LIMIT per client IP, separately from any
+// other traffic, so one client can't saturate disk and bandwidth.
+func (s *Server) limitUploads(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if s.uploadLimiter == nil {
+            next(w, r)
+            return
+        }
+
+        if ok, count, resetAt := s.uploadLimiter.allow(clientIP(r)); !ok {
+            writeQuotaExceeded(w, http.StatusTooManyRequests, "upload_rate", int64(s.uploadLimiter.limit), int64(count), &resetAt,
+                fmt.Sprintf("upload limit of %d per minute reached", s.uploadLimiter.limit))
+            return
+        }
+        next(w, r)
+    }
+}
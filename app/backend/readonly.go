@@ -0,0 +1,57 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+
+    "github.com/gorilla/mux"
+)
+
+// Routes that stay available in read-only mode despite not being GETs
+var readOnlyExempt = map[string]bool{
+    "setReadOnly":       true,
+    "batchGetTodos":     true,
+    "searchTodos":       true,
+    "batchFileMetadata": true,
+}
+
+func (s *Server) blockWritesWhenReadOnly(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet, http.MethodHead, http.MethodOptions:
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        if s.readOnly.Load() {
+            if route := mux.CurrentRoute(r); route == nil || !readOnlyExempt[route.GetName()] {
+                w.Header().Set("Retry-After", "60")
+                writeError(w, http.StatusServiceUnavailable, "service is in read-only mode for maintenance, writes are temporarily disabled")
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func (s *Server) getReadOnly(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]bool{"read_only": s.readOnly.Load()})
+}
+
+func (s *Server) setReadOnly(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        ReadOnly *bool `json:"read_only"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ReadOnly == nil {
+        writeError(w, http.StatusBadRequest, `request body must be {"read_only": true|false}`)
+        return
+    }
+
+    s.readOnly.Store(*body.ReadOnly)
+    log.Printf("Read-only mode set to %t", *body.ReadOnly)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]bool{"read_only": *body.ReadOnly})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+type danglingReference struct {
+    UUID     string `json:"uuid"`
+    FilePath string `json:"file_path"`
+}
+
+// reconcileFiles compares todos' file_path values with what is actually in
+// storage. With ?clear_dangling=true it also clears file_path on
+// todos whose file no longer exists.
+func (s *Server) reconcileFiles(w http.ResponseWriter, r *http.Request) {
+    clear := false
+    if value := r.URL.Query().Get("clear_dangling"); value != "" {
+        var err error
+        if clear, err = strconv.ParseBool(value); err != nil {
+            writeError(w, http.StatusBadRequest, "invalid clear_dangling value")
+            return
+        }
+    }
+
+    files, err := s.storage.List()
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    stored := map[string]bool{}
+    for _, file := range files {
+        stored[file.Name] = true
+    }
+
+    var todos []Todo
+    if err := s.db.Select("id", "uuid", "file_path").Where("file_path <> ''").Find(&todos).Error; err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    // Shared with the orphan listing so both agree on what is referenced
+    referenced, err := s.referencedFileNames()
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    dangling := []danglingReference{}
+    var danglingIDs []jsonID
+    for _, todo := range todos {
+        if !stored[storedName(todo.FilePath)] {
+            dangling = append(dangling, danglingReference{UUID: todo.UUID, FilePath: todo.FilePath})
+            danglingIDs = append(danglingIDs, todo.ID)
+        }
+    }
+
+    unreferenced := []string{}
+    for name := range stored {
+        if !referenced[name] {
+            unreferenced = append(unreferenced, name)
+        }
+    }
+    sort.Strings(unreferenced)
+
+    var cleared int64
+    if clear && len(danglingIDs) > 0 {
+        err := s.db.Transaction(func(tx *gorm.DB) error {
+            result := tx.Model(&Todo{}).Where("id IN ?", danglingIDs).Update("file_path", "")
+            cleared = result.RowsAffected
+            return result.Error
+        })
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "missing_files":      dangling,
+        "unreferenced_files": unreferenced,
+        "cleared":            cleared,
+    })
+}
+
+type orphanedFile struct {
+    Name       string    `json:"name"`
+    Size       int64     `json:"size"`
+    Modified   time.Time `json:"modified"`
+    AgeSeconds int64     `json:"age_seconds"`
+}
+
+// referencedFileNames is every stored name a live todo points at, either as
+// its file_path or as an attachment in the files table
+func (s *Server) referencedFileNames() (map[string]bool, error) {
+    var paths []string
+    if err := s.db.Model(&Todo{}).Where("file_path <> ''").Pluck("file_path", &paths).Error; err != nil {
+        return nil, err
+    }
+    var attached []string
+    err := s.db.Model(&File{}).
+        Where("id IN (?)", s.db.Table("file_attachments").
+            Select("file_attachments.file_id").
+            Joins("JOIN todos ON todos.id = file_attachments.todo_id AND todos.deleted_at IS NULL")).
+        Pluck("name", &attached).Error
+    if err != nil {
+        return nil, err
+    }
+
+    referenced := make(map[string]bool, len(paths)+len(attached))
+    for _, path := range paths {
+        referenced[storedName(path)] = true
+    }
+    for _, name := range attached {
+        referenced[name] = true
+    }
+    return referenced, nil
+}
+
+// listOrphanedFiles reports stored files no todo references, oldest first,
+// without deleting anything
+func (s *Server) listOrphanedFiles(w http.ResponseWriter, r *http.Request) {
+    files, err := s.storage.List()
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    referenced, err := s.referencedFileNames()
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    now := time.Now()
+    orphans := []orphanedFile{}
+    var totalBytes int64
+    for _, file := range files {
+        if referenced[file.Name] {
+            continue
+        }
+        orphans = append(orphans, orphanedFile{
+            Name:       file.Name,
+            Size:       file.Size,
+            Modified:   file.ModTime,
+            AgeSeconds: int64(now.Sub(file.ModTime) / time.Second),
+        })
+        totalBytes += file.Size
+    }
+    sort.Slice(orphans, func(i, j int) bool {
+        return orphans[i].Modified.Before(orphans[j].Modified)
+    })
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "files":       orphans,
+        "count":       len(orphans),
+        "total_bytes": totalBytes,
+    })
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "errors"
+    "io/fs"
+    "log"
+    "net/http"
+    "os"
+
+    "gorm.io/gorm"
+)
+
+// resetData wipes every todo, tag, template and stored file. It is meant for
+// throwaway test environments and only exists when ALLOW_RESET is set.
+func (s *Server) resetData(w http.ResponseWriter, r *http.Request) {
+    if !s.config.AllowReset {
+        writeError(w, http.StatusForbidden, "reset is disabled, set ALLOW_RESET=true to enable it")
+        return
+    }
+
+    var todos, templates int64
+    var trashed []string
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        // Soft-deleted rows are wiped too
+        if err := tx.Unscoped().Model(&Todo{}).Count(&todos).Error; err != nil {
+            return err
+        }
+        if err := tx.Unscoped().Model(&Template{}).Count(&templates).Error; err != nil {
+            return err
+        }
+        if err := tx.Model(&TrashedFile{}).Pluck("name", &trashed).Error; err != nil {
+            return err
+        }
+        return tx.Exec("TRUNCATE todo_tags, comments, drafts, todos, tags, templates, saved_views, files, folders, partial_uploads, trashed_files, file_attachments, ownership_transfers RESTART IDENTITY").Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    // Staged parts of unfinished uploads
+    if err := os.RemoveAll(s.config.PartialUploadDir); err != nil {
+        log.Printf("Reset: failed to remove partial uploads: %v", err)
+    }
+
+    // Trashed files aren't listed with the others
+    for _, name := range trashed {
+        if err := s.storage.Delete(trashName(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+            log.Printf("Reset: failed to delete trashed %s: %v", name, err)
+        }
+    }
+
+    files, err := s.storage.List()
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    var removed int
+    var reclaimed int64
+    for _, file := range files {
+        if err := s.storage.Delete(file.Name); err != nil {
+            if !errors.Is(err, fs.ErrNotExist) {
+                log.Printf("Reset: failed to delete %s: %v", file.Name, err)
+            }
+            continue
+        }
+        if err := s.storage.Delete(thumbnailName(file.Name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+            log.Printf("Reset: failed to delete thumbnail for %s: %v", file.Name, err)
+        }
+        removed++
+        reclaimed += file.Size
+    }
+
+    log.Printf("Reset: removed %d todos, %d templates and %d files", todos, templates, removed)
+    writeJSON(w, http.StatusOK, map[string]int64{
+        "todos":           todos,
+        "templates":       templates,
+        "files":           int64(removed),
+        "reclaimed_bytes": reclaimed,
+    })
+}
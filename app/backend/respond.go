@@ -0,0 +1,147 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "reflect"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// writeJSON sends v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends the API's standard {"error": "..."} body
+func writeError(w http.ResponseWriter, status int, message string) {
+    writeJSON(w, status, map[string]string{"error": message})
+}
+
+var errEmptyBody = errors.New("request body is required")
+
+// decodeJSON decodes the request body into v, reporting a missing body
+// clearly instead of as a bare "EOF".
+func decodeJSON(r *http.Request, v interface{}) error {
+    err := json.NewDecoder(r.Body).Decode(v)
+    if errors.Is(err, io.EOF) {
+        return errEmptyBody
+    }
+    return describeDecodeError(err)
+}
+
+// decodeJSONStrict is decodeJSON but also rejects fields v doesn't have
+func decodeJSONStrict(r *http.Request, v interface{}) error {
+    decoder := json.NewDecoder(r.Body)
+    decoder.DisallowUnknownFields()
+    err := decoder.Decode(v)
+    if errors.Is(err, io.EOF) {
+        return errEmptyBody
+    }
+    return describeDecodeError(err)
+}
+
+// jsonTypeName names the JSON type a Go type decodes from
+func jsonTypeName(t reflect.Type) string {
+    if t == reflect.TypeOf(time.Time{}) {
+        return "an RFC 3339 timestamp string"
+    }
+    switch t.Kind() {
+    case reflect.Pointer:
+        return jsonTypeName(t.Elem())
+    case reflect.Bool:
+        return "a boolean"
+    case reflect.String:
+        return "a string"
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return "an integer"
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return "a non-negative integer"
+    case reflect.Float32, reflect.Float64:
+        return "a number"
+    case reflect.Slice, reflect.Array:
+        return "an array"
+    case reflect.Struct, reflect.Map:
+        return "an object"
+    }
+    return t.String()
+}
+
+// describeDecodeError rewrites encoding/json's errors, which name Go types
+// and struct fields, in terms of the request's JSON fields and types
+func describeDecodeError(err error) error {
+    var syntaxErr *json.SyntaxError
+    var typeErr *json.UnmarshalTypeError
+    var timeErr *time.ParseError
+    switch {
+    case err == nil:
+        return nil
+    case errors.As(err, &syntaxErr):
+        return fmt.Errorf("malformed JSON at byte %d: %s", syntaxErr.Offset, syntaxErr.Error())
+    case errors.Is(err, io.ErrUnexpectedEOF):
+        return errors.New("malformed JSON: body ends before the value is complete")
+    case errors.As(err, &typeErr):
+        if typeErr.Field == "" {
+            return fmt.Errorf("request body must be %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value)
+        }
+        return fmt.Errorf("field %q must be %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+    case errors.As(err, &timeErr):
+        return fmt.Errorf("timestamps must be RFC 3339, such as 2024-01-02T15:04:05Z, got %q", timeErr.Value)
+    case strings.HasPrefix(err.Error(), "json: unknown field "):
+        return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+    }
+    return err
+}
+
+// allowedMethods lists the methods routed for path, in registration order
+func allowedMethods(router *mux.Router, path string) []string {
+    seen := map[string]bool{}
+    var allowed []string
+    router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+        pattern, err := route.GetPathRegexp()
+        if err != nil {
+            return nil
+        }
+        methods, err := route.GetMethods()
+        if err != nil {
+            return nil
+        }
+        if matched, _ := regexp.MatchString(pattern, path); matched {
+            for _, method := range methods {
+                if !seen[method] {
+                    seen[method] = true
+                    allowed = append(allowed, method)
+                }
+            }
+        }
+        return nil
+    })
+    return allowed
+}
+
+// unmatchedRouteHandler answers requests mux couldn't route. It works out the
+// methods the path does support itself, since mux reports some method
+// mismatches as plain not-found.
+func unmatchedRouteHandler(router *mux.Router) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        allowed := allowedMethods(router, r.URL.Path)
+        if len(allowed) == 0 {
+            writeError(w, http.StatusNotFound, "no route for "+r.URL.Path)
+            return
+        }
+
+        w.Header().Set("Allow", strings.Join(allowed, ", "))
+        writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{
+            "error":           "method " + r.Method + " not allowed",
+            "allowed_methods": allowed,
+        })
+    }
+}
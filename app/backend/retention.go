@@ -0,0 +1,80 @@
+package main
+
+import (
+    "log"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// runRetention is one pass of the completed-todo retention worker
+func (s *Server) runRetention() error {
+    if s.readOnly.Load() {
+        log.Println("Retention: skipped, server is read-only")
+        return nil
+    }
+    count, err := s.applyRetention(time.Now().Add(-s.config.CompletedRetention))
+    if err != nil {
+        log.Printf("Retention: failed: %v", err)
+        return err
+    }
+    if count > 0 {
+        log.Printf("Retention: %s %d todos completed before the cutoff", retentionVerb(s.config.RetentionAction), count)
+    }
+    return nil
+}
+
+func retentionVerb(action string) string {
+    if action == "delete" {
+        return "deleted"
+    }
+    return "archived"
+}
+
+// retentionExpired selects the todos completed before cutoff. Rows completed
+// before completed_at was recorded fall back to updated_at.
+func retentionExpired(cutoff time.Time) func(*gorm.DB) *gorm.DB {
+    return func(tx *gorm.DB) *gorm.DB {
+        return tx.Where("completed = ? AND COALESCE(completed_at, updated_at) < ?", true, cutoff)
+    }
+}
+
+// retentionPending counts the todos the next retention pass would act on
+func (s *Server) retentionPending() (int64, error) {
+    var count int64
+    query := s.db.Model(&Todo{}).Scopes(retentionExpired(time.Now().Add(-s.config.CompletedRetention)))
+    if s.config.RetentionAction != "delete" {
+        query = query.Where("archived = ?", false)
+    }
+    err := query.Count(&count).Error
+    return count, err
+}
+
+// applyRetention archives or soft-deletes todos completed before cutoff
+func (s *Server) applyRetention(cutoff time.Time) (int64, error) {
+    expired := retentionExpired(cutoff)
+
+    if s.config.RetentionAction != "delete" {
+        result := s.db.Model(&Todo{}).Scopes(expired).Where("archived = ?", false).Update("archived", true)
+        return result.RowsAffected, result.Error
+    }
+
+    var deleted int64
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var uuids []string
+        if err := tx.Model(&Todo{}).Scopes(expired).Pluck("uuid", &uuids).Error; err != nil || len(uuids) == 0 {
+            return err
+        }
+        // Same clean-up as deleteTodo
+        result := tx.Where("uuid IN ?", uuids).Delete(&Todo{})
+        if result.Error != nil {
+            return result.Error
+        }
+        deleted = result.RowsAffected
+        if err := tx.Where("todo_uuid IN ?", uuids).Delete(&Comment{}).Error; err != nil {
+            return err
+        }
+        return tx.Where("todo_uuid IN ?", uuids).Delete(&Draft{}).Error
+    })
+    return deleted, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "io"
+    "log"
+    "math/rand"
+    "syscall"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    "gorm.io/gorm"
+)
+
+const readRetryBaseDelay = 50 * time.Millisecond
+
+// isTransientDBError reports connection-level failures where re-running the
+// same read is likely to succeed.
+func isTransientDBError(err error) bool {
+    if err == nil {
+        return false
+    }
+    return pgconn.SafeToRetry(err) ||
+        errors.Is(err, driver.ErrBadConn) ||
+        errors.Is(err, io.ErrUnexpectedEOF) ||
+        errors.Is(err, syscall.ECONNRESET) ||
+        errors.Is(err, syscall.ECONNREFUSED) ||
+        errors.Is(err, syscall.EPIPE)
+}
+
+// withReadRetry re-runs a read query with jittered backoff on transient
+// errors. Only use it for reads, writes may not be safe to repeat.
+func (s *Server) withReadRetry(query func() error) error {
+    err := query()
+    for attempt := 1; attempt <= s.config.ReadRetries && isTransientDBError(err); attempt++ {
+        backoff := readRetryBaseDelay << (attempt - 1)
+        delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+        log.Printf("Transient database error, retrying read in %v (attempt %d/%d): %v", delay, attempt, s.config.ReadRetries, err)
+        time.Sleep(delay)
+        err = query()
+    }
+    return err
+}
+
+// Attempts for a serializable transaction before giving up on conflicts
+const maxSerializableAttempts = 3
+
+// isSerializationFailure reports a conflict that aborted a serializable
+// transaction, which is safe to run again from the start.
+func isSerializationFailure(err error) bool {
+    var pgErr *pgconn.PgError
+    return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// withSerializableTx runs fn in a SERIALIZABLE transaction, retrying it with
+// jittered backoff when postgres aborts it because of a concurrent writer.
+func (s *Server) withSerializableTx(fn func(tx *gorm.DB) error) error {
+    var err error
+    for attempt := 1; attempt <= maxSerializableAttempts; attempt++ {
+        err = s.db.Transaction(fn, &sql.TxOptions{Isolation: sql.LevelSerializable})
+        if !isSerializationFailure(err) {
+            return err
+        }
+        backoff := readRetryBaseDelay << (attempt - 1)
+        delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+        log.Printf("Serialization failure, retrying transaction in %v (attempt %d/%d): %v", delay, attempt, maxSerializableAttempts, err)
+        time.Sleep(delay)
+    }
+    return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// SavedView is a named set of GET /todos query parameters, applied with
+// GET /todos?view=<name>
+type SavedView struct {
+    ID        uint              `json:"-" gorm:"primarykey"`
+    Name      string            `json:"name" gorm:"uniqueIndex;not null"`
+    Params    map[string]string `json:"params" gorm:"serializer:json"`
+    CreatedAt time.Time         `json:"created_at"`
+    UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Parameters a saved view may set: the list filters plus the sort
+func savedViewParams() []string {
+    return append(append([]string{}, todoFilterParams...), "sort", "order")
+}
+
+// validate checks the preset the same way GET /todos would
+func (s *Server) validateSavedView(view *SavedView) error {
+    view.Name = strings.TrimSpace(view.Name)
+    if view.Name == "" {
+        return errors.New("name is required")
+    }
+    if len(view.Params) == 0 {
+        return errors.New("params must set at least one parameter")
+    }
+
+    allowed := savedViewParams()
+    query := url.Values{}
+    for name, value := range view.Params {
+        if !contains(allowed, name) {
+            return fmt.Errorf("unknown parameter %q, must be one of %s", name, strings.Join(allowed, ", "))
+        }
+        query.Set(name, value)
+    }
+    probe := &http.Request{URL: &url.URL{RawQuery: query.Encode()}}
+    if _, err := s.parseTodoFilters(probe); err != nil {
+        return err
+    }
+    _, err := todoOrder(query.Get("sort"), query.Get("order"))
+    return err
+}
+
+// applySavedView resolves ?view= into the preset's parameters. Parameters in
+// the request itself win over the preset's.
+func (s *Server) applySavedView(r *http.Request) (*http.Request, error) {
+    query := r.URL.Query()
+    name := query.Get("view")
+    if name == "" {
+        return r, nil
+    }
+
+    var view SavedView
+    if err := s.db.Where("name = ?", name).First(&view).Error; err != nil {
+        return r, err
+    }
+    for param, value := range view.Params {
+        if !query.Has(param) {
+            query.Set(param, value)
+        }
+    }
+    query.Del("view")
+
+    r = r.Clone(r.Context())
+    r.URL.RawQuery = query.Encode()
+    return r, nil
+}
+
+// saveView creates the named view or replaces its parameters
+func (s *Server) saveView(w http.ResponseWriter, r *http.Request) {
+    var view SavedView
+    if err := decodeJSONStrict(r, &view); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if err := s.validateSavedView(&view); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    status := http.StatusOK
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        var existing SavedView
+        err := tx.Where("name = ?", view.Name).First(&existing).Error
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            status = http.StatusCreated
+            return tx.Create(&view).Error
+        }
+        if err != nil {
+            return err
+        }
+        existing.Params = view.Params
+        view = existing
+        return tx.Save(&view).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, status, view)
+}
+
+func (s *Server) listViews(w http.ResponseWriter, r *http.Request) {
+    views := []SavedView{}
+    if err := s.withReadRetry(func() error {
+        return s.db.Order("name").Find(&views).Error
+    }); err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) deleteView(w http.ResponseWriter, r *http.Request) {
+    result := s.db.Where("name = ?", mux.Vars(r)["name"]).Delete(&SavedView{})
+    if result.Error != nil {
+        writeError(w, http.StatusInternalServerError, result.Error.Error())
+        return
+    }
+    if result.RowsAffected == 0 {
+        writeError(w, http.StatusNotFound, "view not found")
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// todoSearch is the body of POST /todos/search. Every field is optional and
+// they combine with AND.
+type todoSearch struct {
+    Text      string     `json:"text"`
+    Completed *bool      `json:"completed"`
+    HasFile   *bool      `json:"has_file"`
+    UUIDs     []string   `json:"uuids"`
+    // Todos carrying any of these tags
+    Tags      []string   `json:"tags"`
+    DueBefore *time.Time `json:"due_before"`
+    DueAfter  *time.Time `json:"due_after"`
+    Sort      string     `json:"sort"`
+    Order     string     `json:"order"`
+    Page      int        `json:"page"`
+    PageSize  int        `json:"page_size"`
+}
+
+// Columns POST /todos/search and GET /todos?sort= can sort by
+var searchSortColumns = []string{"position", "created_at", "updated_at", "title", "due_date"}
+
+// scope builds the filter and the ORDER BY separately, so the filter can also
+// be used for counting.
+func (q todoSearch) scope(encrypted bool) (func(*gorm.DB) *gorm.DB, string, error) {
+    if len(q.UUIDs) > maxBatchUUIDs {
+        return nil, "", fmt.Errorf("at most %d uuids are allowed, got %d", maxBatchUUIDs, len(q.UUIDs))
+    }
+    orderBy, err := todoOrder(q.Sort, q.Order)
+    if err != nil {
+        return nil, "", err
+    }
+    tags, err := cleanTagNames(q.Tags)
+    if err != nil {
+        return nil, "", err
+    }
+
+    return func(tx *gorm.DB) *gorm.DB {
+        if q.Text != "" {
+            tx = matchText(tx, q.Text, encrypted)
+        }
+        if q.Completed != nil {
+            tx = tx.Where("completed = ?", *q.Completed)
+        }
+        if q.HasFile != nil {
+            if *q.HasFile {
+                tx = tx.Where("file_path <> ''")
+            } else {
+                tx = tx.Where("file_path = '' OR file_path IS NULL")
+            }
+        }
+        if len(q.UUIDs) > 0 {
+            tx = tx.Where("uuid IN ?", q.UUIDs)
+        }
+        if len(tags) > 0 {
+            tx = tx.Where("id IN (?)", tx.Session(&gorm.Session{NewDB: true}).
+                Table("todo_tags").
+                Select("todo_tags.todo_id").
+                Joins("JOIN tags ON tags.id = todo_tags.tag_id").
+                Where("tags.name IN ?", tags))
+        }
+        if q.DueBefore != nil {
+            tx = tx.Where("due_date < ?", *q.DueBefore)
+        }
+        if q.DueAfter != nil {
+            tx = tx.Where("due_date >= ?", *q.DueAfter)
+        }
+        return tx
+    }, orderBy, nil
+}
+
+// todoOrder validates a sort column and direction and returns the ORDER BY,
+// position ascending by default
+func todoOrder(sort, order string) (string, error) {
+    if sort == "" {
+        sort = "position"
+    }
+    if !contains(searchSortColumns, sort) {
+        return "", fmt.Errorf("invalid sort %q, must be one of %s", sort, strings.Join(searchSortColumns, ", "))
+    }
+    direction := strings.ToLower(order)
+    if direction == "" {
+        direction = "asc"
+    }
+    if direction != "asc" && direction != "desc" {
+        return "", fmt.Errorf("invalid order %q, must be asc or desc", order)
+    }
+    return sort + " " + direction + ", id", nil
+}
+
+// searchTodos runs a structured query, always returning a page of results in
+// the same envelope as GET /todos?page=.
+func (s *Server) searchTodos(w http.ResponseWriter, r *http.Request) {
+    var query todoSearch
+    if err := decodeJSONStrict(r, &query); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    scope, orderBy, err := query.scope(s.config.FieldCipher != nil)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    page := &Page{Number: query.Page, Size: query.PageSize}
+    if page.Number == 0 {
+        page.Number = 1
+    }
+    if page.Size == 0 {
+        page.Size = s.config.DefaultPageSize
+    }
+    if page.Number < 0 || page.Size < 0 {
+        writeError(w, http.StatusBadRequest, "page and page_size must be positive")
+        return
+    }
+    if page.Size > s.config.MaxPageSize {
+        page.Size = s.config.MaxPageSize
+        page.Clamped = true
+    }
+
+    todos := []Todo{}
+    var total int64
+    err = s.withReadRetry(func() error {
+        if err := s.db.Model(&Todo{}).Scopes(scope).Count(&total).Error; err != nil {
+            return err
+        }
+        return s.db.Preload("Tags").Scopes(scope).Order(orderBy).Offset(page.Offset()).Limit(page.Size).Find(&todos).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "todos": todos,
+        "meta":  s.pageMeta(page, total),
+    })
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/gorilla/mux"
+    "github.com/rs/cors"
+    "golang.org/x/sync/singleflight"
+    "gorm.io/gorm"
+)
+
+// Config is everything read from the environment at startup
+type Config struct {
+    // Proxies allowed to set X-Forwarded-For / X-Real-IP
+    TrustedProxies []*net.IPNet
+    // Retries for reads that fail with a transient connection error
+    ReadRetries int
+    // Longest side of generated image thumbnails
+    ThumbnailMaxDim int
+    // Cap on the total size of stored uploads, 0 means unlimited
+    MaxUploadDirBytes int64
+    // Bearer token for the admin API, empty disables it
+    AdminToken string
+    // Longest todo description accepted, also enforced by a DB constraint
+    MaxDescriptionBytes int64
+    // Seals todo descriptions at rest, nil when FIELD_ENCRYPTION_KEY is unset
+    FieldCipher *fieldCipher
+    // Start in read-only mode
+    ReadOnly bool
+    // Enables POST /admin/reset, never set this in production
+    AllowReset bool
+    // Reject due dates in the past on create unless ?allow_past=true
+    RejectPastDueDates bool
+    // How stored uploads are named, one of fileNamingStrategies
+    FileNaming string
+    // Bytes of a multipart upload buffered in memory before using temp files
+    MultipartMaxMemory int64
+    // Uploads allowed per client IP per minute, 0 means unlimited
+    UploadRateLimit int
+    // First day of the week for ?due=this_week
+    WeekStart time.Weekday
+    // Page size for ?page= when no page_size is given, and its upper bound
+    DefaultPageSize int
+    MaxPageSize     int
+
+    // Completed todos older than this are archived or deleted, 0 disables it
+    CompletedRetention time.Duration
+    // What retention does to old completed todos: archive or delete
+    RetentionAction string
+
+    // Cap on the rows in one export, 0 means unlimited
+    ExportMaxRows int64
+    // Fraction of fast, successful requests that get logged
+    LogSampleRate float64
+    // Requests slower than this are always logged
+    LogSlowThreshold time.Duration
+    // Share metadata lookups between concurrent downloads of the same file
+    DedupeFileLookups bool
+    // Queries slower than this are logged with their SQL, 0 disables it
+    SlowQueryThreshold time.Duration
+    // Log queries with ? placeholders instead of their parameter values
+    LogRedactParams bool
+
+    // Cap on the bytes attached to one owner's todos, 0 for no cap
+    PerOwnerStorageBytes int64
+
+    // How long DELETE /files/{filename} keeps a file in the trash, 0 deletes
+    // it right away
+    FileTrashRetention time.Duration
+
+    // Run migrations on startup, off when they run as a separate step
+    AutoMigrate bool
+
+    // ?token= for the iCalendar feed, which is disabled while empty
+    CalendarToken string
+
+    // Compression level for gzipped exports and zipped archives, 1 (fastest)
+    // to 9 (smallest)
+    GzipLevel int
+
+    // Version of newly generated todo UUIDs, 4 (random) or 7 (time-ordered)
+    UUIDVersion int
+
+    // Where ranged uploads are staged until complete, and how long an
+    // unfinished one is kept without receiving a part
+    PartialUploadDir string
+    PartialUploadTTL time.Duration
+
+    // What to do with a trailing slash, one of trailingSlashModes
+    TrailingSlash string
+
+    EnablePprof        bool
+    CORSAllowedHeaders []string
+    CORSMaxAge         int
+}
+
+func loadConfig() (Config, error) {
+    trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+    if err != nil {
+        return Config{}, fmt.Errorf("failed to parse TRUSTED_PROXIES: %v", err)
+    }
+
+    fieldCipher, err := newFieldCipher(os.Getenv("FIELD_ENCRYPTION_KEY"))
+    if err != nil {
+        return Config{}, fmt.Errorf("invalid FIELD_ENCRYPTION_KEY: %v", err)
+    }
+
+    config := Config{
+        FieldCipher:        fieldCipher,
+        MaxDescriptionBytes: envInt64("MAX_DESCRIPTION_BYTES", 10000),
+        TrustedProxies:     trustedProxies,
+        ReadRetries:        int(envInt64("DB_READ_RETRIES", 2)),
+        ThumbnailMaxDim:    int(envInt64("THUMBNAIL_MAX_DIM", 256)),
+        MaxUploadDirBytes:  envInt64("MAX_UPLOAD_DIR_BYTES", 0),
+        PerOwnerStorageBytes: envInt64("PER_OWNER_STORAGE_BYTES", 0),
+        AdminToken:         os.Getenv("ADMIN_TOKEN"),
+        CalendarToken:      os.Getenv("CALENDAR_TOKEN"),
+        FileTrashRetention: time.Duration(envInt64("FILE_TRASH_DAYS", 7)) * 24 * time.Hour,
+        ReadOnly:           envBool("READ_ONLY", false),
+        AutoMigrate:        envBool("AUTO_MIGRATE", true),
+        AllowReset:         envBool("ALLOW_RESET", false),
+        RejectPastDueDates: envBool("REJECT_PAST_DUE_DATES", true),
+        FileNaming:         os.Getenv("FILE_NAMING"),
+        MultipartMaxMemory: envInt64("MULTIPART_MAX_MEMORY", 32<<20),
+        UploadRateLimit:    int(envInt64("UPLOAD_RATE_LIMIT", 10)),
+        DefaultPageSize:    int(envInt64("DEFAULT_PAGE_SIZE", 25)),
+        MaxPageSize:        int(envInt64("MAX_PAGE_SIZE", 100)),
+        ExportMaxRows:      envInt64("EXPORT_MAX_ROWS", 0),
+        CompletedRetention: time.Duration(envInt64("COMPLETED_RETENTION_DAYS", 0)) * 24 * time.Hour,
+        RetentionAction:    os.Getenv("COMPLETED_RETENTION_ACTION"),
+        LogSampleRate:      envFloat("LOG_SAMPLE_RATE", 1),
+        LogSlowThreshold:   time.Duration(envInt64("LOG_SLOW_THRESHOLD_MS", 1000)) * time.Millisecond,
+        SlowQueryThreshold: time.Duration(envInt64("SLOW_QUERY_MS", 200)) * time.Millisecond,
+        LogRedactParams:    envBool("LOG_REDACT_PARAMS", true),
+        DedupeFileLookups:  envBool("DEDUPE_FILE_LOOKUPS", true),
+        UUIDVersion:        int(envInt64("UUID_VERSION", 4)),
+        GzipLevel:          int(envInt64("GZIP_LEVEL", 6)),
+        PartialUploadDir:   os.Getenv("PARTIAL_UPLOAD_DIR"),
+        PartialUploadTTL:   time.Duration(envInt64("PARTIAL_UPLOAD_TTL_HOURS", 24)) * time.Hour,
+        EnablePprof:        envBool("ENABLE_PPROF", false),
+        CORSAllowedHeaders: envList("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Authorization", "X-Request-ID"}),
+        // Lets browsers cache preflight responses instead of re-sending OPTIONS
+        CORSMaxAge: int(envInt64("CORS_MAX_AGE", 7200)),
+    }
+    if config.MaxDescriptionBytes <= 0 {
+        return Config{}, fmt.Errorf("MAX_DESCRIPTION_BYTES must be positive")
+    }
+    if config.ThumbnailMaxDim == 0 {
+        return Config{}, fmt.Errorf("THUMBNAIL_MAX_DIM must be positive")
+    }
+    switch weekStart := strings.ToLower(os.Getenv("WEEK_START")); weekStart {
+    case "", "monday":
+        config.WeekStart = time.Monday
+    case "sunday":
+        config.WeekStart = time.Sunday
+    default:
+        return Config{}, fmt.Errorf("WEEK_START must be monday or sunday, got %q", weekStart)
+    }
+    if config.TrailingSlash, err = parseTrailingSlash(os.Getenv("TRAILING_SLASH")); err != nil {
+        return Config{}, err
+    }
+    if config.GzipLevel < 1 || config.GzipLevel > 9 {
+        return Config{}, fmt.Errorf("GZIP_LEVEL must be between 1 and 9, got %d", config.GzipLevel)
+    }
+    if config.UUIDVersion != 4 && config.UUIDVersion != 7 {
+        return Config{}, fmt.Errorf("UUID_VERSION must be 4 or 7, got %d", config.UUIDVersion)
+    }
+    if config.PartialUploadDir == "" {
+        config.PartialUploadDir = filepath.Join(os.TempDir(), "partial-uploads")
+    }
+    if config.PartialUploadTTL <= 0 {
+        return Config{}, fmt.Errorf("PARTIAL_UPLOAD_TTL_HOURS must be positive")
+    }
+    if config.FileNaming == "" {
+        config.FileNaming = "timestamp"
+    }
+    if !contains(fileNamingStrategies, config.FileNaming) {
+        return Config{}, fmt.Errorf("FILE_NAMING must be one of %s, got %q", strings.Join(fileNamingStrategies, ", "), config.FileNaming)
+    }
+    if config.RetentionAction == "" {
+        config.RetentionAction = "archive"
+    }
+    if config.RetentionAction != "archive" && config.RetentionAction != "delete" {
+        return Config{}, fmt.Errorf("COMPLETED_RETENTION_ACTION must be archive or delete, got %q", config.RetentionAction)
+    }
+    if config.LogSampleRate > 1 {
+        return Config{}, fmt.Errorf("LOG_SAMPLE_RATE must be between 0 and 1, got %v", config.LogSampleRate)
+    }
+    if config.DefaultPageSize == 0 || config.MaxPageSize == 0 {
+        return Config{}, fmt.Errorf("DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE must be positive")
+    }
+    if config.DefaultPageSize > config.MaxPageSize {
+        return Config{}, fmt.Errorf("DEFAULT_PAGE_SIZE (%d) must not exceed MAX_PAGE_SIZE (%d)", config.DefaultPageSize, config.MaxPageSize)
+    }
+    return config, nil
+}
+
+// Server holds the dependencies shared by the HTTP handlers
+type Server struct {
+    db      *gorm.DB
+    storage Storage
+    config  Config
+
+    // When set, every write is rejected with 503 while reads keep working
+    readOnly atomic.Bool
+
+    // nil when uploads are not rate limited
+    uploadLimiter *rateLimiter
+
+    // Deduplicates concurrent file lookups, see dedupe.go
+    lookups singleflight.Group
+
+    // Background jobs, see startWorker
+    workersMu sync.Mutex
+    workers   []*worker
+}
+
+func NewServer(db *gorm.DB, storage Storage, config Config) *Server {
+    s := &Server{db: db, storage: storage, config: config}
+    // Maintenance switch, can also be flipped at runtime via the admin API
+    s.readOnly.Store(config.ReadOnly)
+    if config.UploadRateLimit > 0 {
+        s.uploadLimiter = newRateLimiter(config.UploadRateLimit, time.Minute)
+    }
+    return s
+}
+
+// routes builds the router along with the CORS and client IP middleware
+func (s *Server) routes() http.Handler {
+    r := mux.NewRouter()
+
+    // Subrouter for "/api" prefix
+    api := r.PathPrefix("/api").Subrouter()
+    api.Use(s.blockWritesWhenReadOnly)
+
+    // JSON errors for unknown routes and wrong methods instead of mux's plain text
+    r.NotFoundHandler = unmatchedRouteHandler(r)
+    r.MethodNotAllowedHandler = r.NotFoundHandler
+    api.NotFoundHandler = r.NotFoundHandler
+    api.MethodNotAllowedHandler = r.NotFoundHandler
+
+    // Readiness probe, fails when the database or uploads volume is unusable
+    r.HandleFunc("/readyz", s.readyz).Methods("GET")
+
+    // Profiling endpoints, off by default
+    if s.config.EnablePprof {
+        registerPprof(r)
+        log.Println("pprof endpoints enabled on /debug/pprof/")
+    }
+
+    // CRUD Routes for Todos
+    api.HandleFunc("/todos", requireJSON(s.createTodo)).Methods("POST")
+    api.HandleFunc("/todos", s.getAllTodos).Methods("GET")
+    api.HandleFunc("/todos", requireJSON(s.patchTodos)).Methods("PATCH")
+    api.HandleFunc("/todos/search", requireJSON(s.searchTodos)).Methods("POST").Name("searchTodos")
+    api.HandleFunc("/todos/from-files", s.limitUploads(s.createTodosFromFiles)).Methods("POST")
+    api.HandleFunc("/todos/import", requireJSON(s.importTodos)).Methods("POST")
+    api.HandleFunc("/todos/export", s.exportTodos).Methods("GET")
+    api.HandleFunc("/todos/grouped", s.getGroupedTodos).Methods("GET")
+    api.HandleFunc("/todos/recent", s.getRecentTodos).Methods("GET")
+    api.HandleFunc("/todos/upcoming", s.getUpcomingTodos).Methods("GET")
+    api.HandleFunc("/todos/stats/daily", s.getDailyStats).Methods("GET")
+    api.HandleFunc("/todos/calendar.ics", s.getTodoCalendar).Methods("GET")
+    api.HandleFunc("/todos/batch", requireJSON(s.batchTodos)).Methods("POST")
+    api.HandleFunc("/todos/batch-get", requireJSON(s.batchGetTodos)).Methods("POST").Name("batchGetTodos")
+    api.HandleFunc("/todos/tags", requireJSON(s.bulkTagTodos)).Methods("POST")
+    api.HandleFunc("/tags", s.listTagCounts).Methods("GET")
+    api.HandleFunc("/views", requireJSON(s.saveView)).Methods("POST")
+    api.HandleFunc("/views", s.listViews).Methods("GET")
+    api.HandleFunc("/views/{name}", s.deleteView).Methods("DELETE")
+    api.HandleFunc("/todos/order", requireJSON(s.reorderTodos)).Methods("PUT")
+    api.HandleFunc("/todos/archive", s.archiveTodos).Methods("POST")
+    api.HandleFunc("/todos/complete-all", s.setAllCompleted(true)).Methods("POST")
+    api.HandleFunc("/todos/incomplete-all", s.setAllCompleted(false)).Methods("POST")
+    api.HandleFunc("/todos/{uuid}", s.getTodo).Methods("GET")
+    api.HandleFunc("/todos/{uuid}", requireJSON(s.updateTodo)).Methods("PUT")
+    api.HandleFunc("/todos/{uuid}", s.deleteTodo).Methods("DELETE")
+    api.HandleFunc("/todos/{uuid}/transfer", s.requireAdmin(requireJSON(s.transferTodo))).Methods("POST")
+    api.HandleFunc("/todos/{uuid}/comments", requireJSON(s.createComment)).Methods("POST")
+    api.HandleFunc("/todos/{uuid}/comments", s.listComments).Methods("GET")
+    api.HandleFunc("/todos/{uuid}/archive", s.downloadTodoArchive).Methods("GET")
+    api.HandleFunc("/todos/{uuid}/draft", requireJSON(s.saveDraft)).Methods("PUT")
+    api.HandleFunc("/todos/{uuid}/draft", s.getDraft).Methods("GET")
+    api.HandleFunc("/todos/{uuid}/draft", s.discardDraft).Methods("DELETE")
+    api.HandleFunc("/todos/{uuid}/draft/commit", s.commitDraft).Methods("POST")
+
+    // Capability discovery
+    api.HandleFunc("/todos", s.describeResource(r, "todos", "Collection of todos")).Methods("OPTIONS")
+    api.HandleFunc("/todos/{uuid}", s.describeResource(r, "todo", "A single todo, addressed by uuid")).Methods("OPTIONS")
+
+    // Todo templates
+    api.HandleFunc("/templates", requireJSON(s.createTemplate)).Methods("POST")
+    api.HandleFunc("/templates", s.listTemplates).Methods("GET")
+    api.HandleFunc("/templates/{name}", s.getTemplate).Methods("GET")
+    api.HandleFunc("/templates/{name}", s.deleteTemplate).Methods("DELETE")
+    api.HandleFunc("/templates/{name}/instantiate", requireJSON(s.instantiateTemplate)).Methods("POST")
+
+    // File system routes
+    api.HandleFunc("/files/upload", s.limitUploads(s.uploadFile)).Methods("POST")
+    api.HandleFunc("/files", s.deleteOldFiles).Methods("DELETE")
+    api.HandleFunc("/files/list", s.listFiles).Methods("GET")
+    api.HandleFunc("/files/download/{filename}", s.downloadFile).Methods("GET", "HEAD")
+    api.HandleFunc("/files/by-hash/{sha256}", s.downloadByHash).Methods("GET")
+    api.HandleFunc("/files/thumbnail/{filename}", s.getThumbnail).Methods("GET")
+    api.HandleFunc("/files/preview/{filename}", s.previewFile).Methods("GET")
+    api.HandleFunc("/files/uploads", s.limitUploads(requireJSON(s.createPartialUpload))).Methods("POST")
+    api.HandleFunc("/files/uploads/{id}", s.getPartialUpload).Methods("GET")
+    api.HandleFunc("/files/uploads/{id}", s.uploadPart).Methods("PUT", "PATCH")
+    api.HandleFunc("/files/uploads/{id}", s.abortPartialUpload).Methods("DELETE")
+    api.HandleFunc("/files/folders", requireJSON(s.createFolder)).Methods("POST")
+    api.HandleFunc("/files/folders", s.listFolders).Methods("GET")
+    api.HandleFunc("/files/largest", s.getLargestFiles).Methods("GET")
+    api.HandleFunc("/files/metadata", requireJSON(s.batchFileMetadata)).Methods("POST").Name("batchFileMetadata")
+    api.HandleFunc("/files/trash", s.listTrash).Methods("GET")
+    api.HandleFunc("/files/{filename}/restore", s.restoreFile).Methods("POST")
+    api.HandleFunc("/files/{filename}/move", requireJSON(s.moveFile)).Methods("POST")
+    api.HandleFunc("/files/{filename}", requireJSON(s.renameFile)).Methods("PUT")
+    api.HandleFunc("/files/{filename}", s.deleteFile).Methods("DELETE")
+
+    // Admin routes
+    api.HandleFunc("/admin/read-only", s.requireAdmin(s.getReadOnly)).Methods("GET")
+    api.HandleFunc("/admin/read-only", s.requireAdmin(requireJSON(s.setReadOnly))).Methods("PUT").Name("setReadOnly")
+    api.HandleFunc("/admin/files/orphaned", s.requireAdmin(s.listOrphanedFiles)).Methods("GET")
+    api.HandleFunc("/admin/files/reconcile", s.requireAdmin(s.reconcileFiles)).Methods("POST")
+    api.HandleFunc("/admin/reset", s.requireAdmin(s.resetData)).Methods("POST")
+    api.HandleFunc("/admin/workers", s.requireAdmin(s.listWorkers)).Methods("GET")
+    api.HandleFunc("/admin/workers/metrics", s.requireAdmin(s.workerMetrics)).Methods("GET")
+
+    // allow all origins and headers
+    return cors.New(cors.Options{
+        AllowedOrigins: []string{"*"},
+        AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+        AllowedHeaders: s.config.CORSAllowedHeaders,
+        MaxAge:         s.config.CORSMaxAge,
+    }).Handler(s.withClientIP(s.logRequests(s.handleTrailingSlash(r))))
+}
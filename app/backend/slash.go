@@ -0,0 +1,62 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/gorilla/mux"
+)
+
+// Ways of handling a trailing slash on a path only registered without one
+var trailingSlashModes = []string{"redirect", "strip", "strict"}
+
+// routeMatches reports whether req is served by a real route, not the
+// not-found or method-not-allowed handlers
+func routeMatches(router *mux.Router, req *http.Request) bool {
+    var match mux.RouteMatch
+    return router.Match(req, &match) && match.MatchErr == nil
+}
+
+// handleTrailingSlash makes /api/todos/ behave like /api/todos according to
+// TRAILING_SLASH: redirect answers 301 (308 for methods with a body, which
+// keeps the method), strip serves it directly and strict leaves it a 404.
+// Paths that are registered with a slash, such as /debug/pprof/, are served
+// as they are.
+func (s *Server) handleTrailingSlash(router *mux.Router) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        path := r.URL.Path
+        if s.config.TrailingSlash == "strict" || len(path) <= 1 || !strings.HasSuffix(path, "/") || routeMatches(router, r) {
+            router.ServeHTTP(w, r)
+            return
+        }
+
+        trimmed := r.Clone(r.Context())
+        trimmed.URL.Path = strings.TrimRight(path, "/")
+        trimmed.URL.RawPath = ""
+        if trimmed.URL.Path == "" || !routeMatches(router, trimmed) {
+            router.ServeHTTP(w, r)
+            return
+        }
+
+        if s.config.TrailingSlash == "strip" {
+            router.ServeHTTP(w, trimmed)
+            return
+        }
+        status := http.StatusMovedPermanently
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            status = http.StatusPermanentRedirect
+        }
+        http.Redirect(w, r, trimmed.URL.RequestURI(), status)
+    })
+}
+
+func parseTrailingSlash(value string) (string, error) {
+    if value == "" {
+        return "redirect", nil
+    }
+    if !contains(trailingSlashModes, value) {
+        return "", fmt.Errorf("TRAILING_SLASH must be one of %s, got %q", strings.Join(trailingSlashModes, ", "), value)
+    }
+    return value, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "os"
+    "time"
+)
+
+const (
+    // Range of GET /todos/stats/daily when from is not given, in days
+    defaultStatsDays = 30
+    maxStatsDays     = 366
+)
+
+type dailyStats struct {
+    Date      string `json:"date"`
+    Completed int64  `json:"completed"`
+    Created   int64  `json:"created"`
+}
+
+// statsZone is the zone days are cut in, the server's TZ like ?due= uses
+func statsZone() string {
+    if tz := os.Getenv("TZ"); tz != "" {
+        return tz
+    }
+    return "UTC"
+}
+
+// parseStatsRange reads ?from= and ?to= as inclusive YYYY-MM-DD dates,
+// defaulting to the last 30 days
+func parseStatsRange(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+    to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    if value := r.URL.Query().Get("to"); value != "" {
+        parsed, err := time.ParseInLocation(time.DateOnly, value, now.Location())
+        if err != nil {
+            return time.Time{}, time.Time{}, fmt.Errorf("invalid to %q, must be YYYY-MM-DD", value)
+        }
+        to = parsed
+    }
+    from := to.AddDate(0, 0, 1-defaultStatsDays)
+    if value := r.URL.Query().Get("from"); value != "" {
+        parsed, err := time.ParseInLocation(time.DateOnly, value, now.Location())
+        if err != nil {
+            return time.Time{}, time.Time{}, fmt.Errorf("invalid from %q, must be YYYY-MM-DD", value)
+        }
+        from = parsed
+    }
+    if from.After(to) {
+        return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+    }
+    if to.Sub(from) >= maxStatsDays*24*time.Hour {
+        return time.Time{}, time.Time{}, fmt.Errorf("range must be at most %d days", maxStatsDays)
+    }
+    return from, to, nil
+}
+
+// getDailyStats counts todos created and completed per day, including days
+// where nothing happened
+func (s *Server) getDailyStats(w http.ResponseWriter, r *http.Request) {
+    from, to, err := parseStatsRange(r, time.Now())
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    end := to.AddDate(0, 0, 1)
+    zone := statsZone()
+
+    countByDay := func(column string) (map[string]int64, error) {
+        var rows []struct {
+            Day   string
+            Count int64
+        }
+        day := fmt.Sprintf("to_char(date_trunc('day', %s AT TIME ZONE ?), 'YYYY-MM-DD')", column)
+        err := s.withReadRetry(func() error {
+            rows = nil
+            return s.db.Model(&Todo{}).
+                Select(day+" AS day, COUNT(*) AS count", zone).
+                Where(column+" >= ? AND "+column+" < ?", from, end).
+                Group("day").
+                Scan(&rows).Error
+        })
+        counts := make(map[string]int64, len(rows))
+        for _, row := range rows {
+            counts[row.Day] = row.Count
+        }
+        return counts, err
+    }
+
+    created, err := countByDay("created_at")
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    completed, err := countByDay("completed_at")
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    stats := []dailyStats{}
+    for day := from; day.Before(end); day = day.AddDate(0, 0, 1) {
+        date := day.Format(time.DateOnly)
+        stats = append(stats, dailyStats{Date: date, Completed: completed[date], Created: created[date]})
+    }
+    writeJSON(w, http.StatusOK, stats)
+}
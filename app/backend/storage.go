@@ -0,0 +1,264 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const defaultUploadDir = "/app/uploads"
+
+type FileInfo struct {
+    Name    string
+    Size    int64
+    ModTime time.Time
+    // From the file metadata, not the backend; see withLastAccessed
+    LastAccessed time.Time
+}
+
+// Storage is where uploaded files live. Names are flat file names, except for
+// derived files such as thumbnails which use a "thumbnails/" prefix. Missing
+// files are reported with errors wrapping fs.ErrNotExist.
+type Storage interface {
+    Save(name string, r io.Reader, size int64) error
+    Open(name string) (io.ReadCloser, error)
+    Stat(name string) (FileInfo, error)
+    Delete(name string) error
+    Rename(oldName, newName string) error
+    // List returns the top-level files, skipping derived files
+    List() ([]FileInfo, error)
+    // Location is what gets recorded as a todo's file_path
+    Location(name string) string
+}
+
+// newStorage picks the backend from STORAGE_BACKEND (local or s3)
+func newStorage() (Storage, error) {
+    switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+    case "", "local":
+        return newLocalStorage(defaultUploadDir)
+    case "s3":
+        return newS3Storage()
+    default:
+        return nil, fmt.Errorf("unknown STORAGE_BACKEND %q, must be local or s3", backend)
+    }
+}
+
+// storedName maps a todo's file_path back to the storage name
+func storedName(location string) string {
+    return filepath.Base(location)
+}
+
+// localStorage shards flat names into two levels of subdirectories taken from
+// the SHA-256 of the name (uploads/ab/cd/<file>), so no single directory grows
+// huge. Files saved before sharding stay readable at the top level.
+type localStorage struct {
+    dir string
+}
+
+func newLocalStorage(dir string) (*localStorage, error) {
+    if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+        return nil, fmt.Errorf("failed to create uploads directory: %v", err)
+    }
+    return &localStorage{dir: dir}, nil
+}
+
+func shardDir(name string) string {
+    sum := sha256.Sum256([]byte(name))
+    digest := hex.EncodeToString(sum[:2])
+    return filepath.Join(digest[:2], digest[2:])
+}
+
+// isShardDir matches the two hex character directory names made by shardDir
+func isShardDir(name string) bool {
+    if len(name) != 2 {
+        return false
+    }
+    _, err := hex.DecodeString(name)
+    return err == nil && strings.ToLower(name) == name
+}
+
+// legacyPath is where a file lived before sharding. Derived files with a
+// prefix such as thumbnails/ are not sharded, so this is also their only path.
+func (s *localStorage) legacyPath(name string) (string, error) {
+    path := filepath.Join(s.dir, filepath.FromSlash(name))
+    if !strings.HasPrefix(path, s.dir+string(filepath.Separator)) {
+        return "", fmt.Errorf("invalid file name %q", name)
+    }
+    return path, nil
+}
+
+// unavailable flags errors caused by the uploads directory itself being gone
+// (volume unmounted, directory deleted), so they don't read as a missing file
+func (s *localStorage) unavailable(err error) error {
+    if err == nil {
+        return nil
+    }
+    if _, statErr := os.Stat(s.dir); statErr != nil {
+        return fmt.Errorf("%w: %v", errStorageUnavailable, statErr)
+    }
+    return err
+}
+
+// path is where name is written
+func (s *localStorage) path(name string) (string, error) {
+    path, err := s.legacyPath(name)
+    if err != nil || strings.Contains(name, "/") {
+        return path, err
+    }
+    return filepath.Join(s.dir, shardDir(name), filepath.Base(path)), nil
+}
+
+// resolve is where name is read from, falling back to the unsharded path
+func (s *localStorage) resolve(name string) (string, error) {
+    path, err := s.path(name)
+    if err != nil {
+        return "", err
+    }
+    if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+        legacy, _ := s.legacyPath(name)
+        if _, err := os.Lstat(legacy); err == nil {
+            return legacy, nil
+        }
+    }
+    return path, nil
+}
+
+// dropLegacy removes an unsharded copy that a new write at name would shadow
+func (s *localStorage) dropLegacy(name, path string) {
+    if legacy, err := s.legacyPath(name); err == nil && legacy != path {
+        os.Remove(legacy)
+    }
+}
+
+func (s *localStorage) Save(name string, r io.Reader, size int64) error {
+    path, err := s.path(name)
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat(s.dir); err != nil {
+        // Don't recreate a missing uploads directory on the container's disk
+        return s.unavailable(err)
+    }
+    if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+        return err
+    }
+
+    out, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(out, r); err != nil {
+        out.Close()
+        os.Remove(path)
+        return err
+    }
+    if err := out.Close(); err != nil {
+        os.Remove(path)
+        return err
+    }
+    s.dropLegacy(name, path)
+    return nil
+}
+
+func (s *localStorage) Open(name string) (io.ReadCloser, error) {
+    path, err := s.resolve(name)
+    if err != nil {
+        return nil, err
+    }
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, s.unavailable(err)
+    }
+    return file, nil
+}
+
+func (s *localStorage) Stat(name string) (FileInfo, error) {
+    path, err := s.resolve(name)
+    if err != nil {
+        return FileInfo{}, err
+    }
+    info, err := os.Stat(path)
+    if err != nil {
+        return FileInfo{}, s.unavailable(err)
+    }
+    if info.IsDir() {
+        return FileInfo{}, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+    }
+    return FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *localStorage) Delete(name string) error {
+    path, err := s.resolve(name)
+    if err != nil {
+        return err
+    }
+    return s.unavailable(os.Remove(path))
+}
+
+func (s *localStorage) Rename(oldName, newName string) error {
+    oldPath, err := s.resolve(oldName)
+    if err != nil {
+        return err
+    }
+    newPath, err := s.path(newName)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(newPath), os.ModePerm); err != nil {
+        return err
+    }
+    if err := os.Rename(oldPath, newPath); err != nil {
+        return s.unavailable(err)
+    }
+    s.dropLegacy(newName, newPath)
+    return nil
+}
+
+// List walks the shard directories plus any unsharded top-level files
+func (s *localStorage) List() ([]FileInfo, error) {
+    files, err := s.listDir(s.dir, 0)
+    return files, s.unavailable(err)
+}
+
+func (s *localStorage) listDir(dir string, depth int) ([]FileInfo, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var files []FileInfo
+    for _, entry := range entries {
+        if entry.IsDir() && depth < 2 && isShardDir(entry.Name()) {
+            shard, err := s.listDir(filepath.Join(dir, entry.Name()), depth+1)
+            if err != nil {
+                return nil, err
+            }
+            files = append(files, shard...)
+            continue
+        }
+        // Files only live at the top (legacy) or the bottom of the shards
+        if !entry.Type().IsRegular() || depth == 1 {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            // Removed between ReadDir and Info
+            continue
+        }
+        files = append(files, FileInfo{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+    }
+    return files, nil
+}
+
+// Location stays the unsharded path, which keeps recorded file_path values
+// stable; storedName only needs the base name
+func (s *localStorage) Location(name string) string {
+    return filepath.Join(s.dir, name)
+}
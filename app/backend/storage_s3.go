@@ -0,0 +1,132 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "mime"
+    "os"
+    "path"
+    "strings"
+
+    "github.com/minio/minio-go/v7"
+    "github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Storage keeps uploads in an S3-compatible bucket (AWS S3, MinIO, ...)
+type s3Storage struct {
+    client *minio.Client
+    bucket string
+    prefix string
+}
+
+func newS3Storage() (*s3Storage, error) {
+    endpoint := os.Getenv("S3_ENDPOINT")
+    bucket := os.Getenv("S3_BUCKET")
+    if endpoint == "" || bucket == "" {
+        return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for the s3 storage backend")
+    }
+
+    client, err := minio.New(endpoint, &minio.Options{
+        Creds:  credentials.NewStaticV4(os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"), ""),
+        Secure: envBool("S3_USE_SSL", true),
+        Region: os.Getenv("S3_REGION"),
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to create S3 client: %v", err)
+    }
+
+    exists, err := client.BucketExists(context.Background(), bucket)
+    if err != nil {
+        return nil, fmt.Errorf("failed to reach bucket %q: %v", bucket, err)
+    }
+    if !exists {
+        return nil, fmt.Errorf("bucket %q does not exist", bucket)
+    }
+
+    prefix := strings.Trim(os.Getenv("S3_PREFIX"), "/")
+    if prefix != "" {
+        prefix += "/"
+    }
+    return &s3Storage{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+    return s.prefix + name
+}
+
+// translate maps S3's missing-object error onto fs.ErrNotExist
+func (s *s3Storage) translate(name string, err error) error {
+    if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+        return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+    }
+    return err
+}
+
+func (s *s3Storage) Save(name string, r io.Reader, size int64) error {
+    contentType := mime.TypeByExtension(path.Ext(name))
+    if contentType == "" {
+        contentType = "application/octet-stream"
+    }
+    _, err := s.client.PutObject(context.Background(), s.bucket, s.key(name), r, size, minio.PutObjectOptions{ContentType: contentType})
+    return err
+}
+
+func (s *s3Storage) Open(name string) (io.ReadCloser, error) {
+    // GetObject is lazy, stat first so missing files fail here
+    if _, err := s.Stat(name); err != nil {
+        return nil, err
+    }
+    object, err := s.client.GetObject(context.Background(), s.bucket, s.key(name), minio.GetObjectOptions{})
+    return object, s.translate(name, err)
+}
+
+func (s *s3Storage) Stat(name string) (FileInfo, error) {
+    info, err := s.client.StatObject(context.Background(), s.bucket, s.key(name), minio.StatObjectOptions{})
+    if err != nil {
+        return FileInfo{}, s.translate(name, err)
+    }
+    return FileInfo{Name: name, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *s3Storage) Delete(name string) error {
+    if _, err := s.Stat(name); err != nil {
+        return err
+    }
+    return s.client.RemoveObject(context.Background(), s.bucket, s.key(name), minio.RemoveObjectOptions{})
+}
+
+// Rename copies then deletes, S3 has no native rename
+func (s *s3Storage) Rename(oldName, newName string) error {
+    _, err := s.client.CopyObject(context.Background(),
+        minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(newName)},
+        minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(oldName)},
+    )
+    if err != nil {
+        return s.translate(oldName, err)
+    }
+    return s.client.RemoveObject(context.Background(), s.bucket, s.key(oldName), minio.RemoveObjectOptions{})
+}
+
+func (s *s3Storage) List() ([]FileInfo, error) {
+    var files []FileInfo
+    objects := s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: s.prefix})
+    for object := range objects {
+        if object.Err != nil {
+            return nil, object.Err
+        }
+        name := strings.TrimPrefix(object.Key, s.prefix)
+        // Non-recursive listings report sub-prefixes such as thumbnails/
+        if name == "" || strings.HasSuffix(name, "/") {
+            continue
+        }
+        files = append(files, FileInfo{Name: name, Size: object.Size, ModTime: object.LastModified})
+    }
+    return files, nil
+}
+
+func (s *s3Storage) Location(name string) string {
+    return fmt.Sprintf("s3://%s/%s", s.bucket, s.key(name))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "syscall"
+)
+
+// errStorageUnavailable marks failures caused by the storage itself being
+// gone, such as the uploads volume no longer being mounted
+var errStorageUnavailable = errors.New("file storage is unavailable")
+
+// storageErrorStatus tells storage outages apart from bugs: 507 when the
+// storage is full, 503 when it is read-only or unreachable, 500 otherwise.
+func storageErrorStatus(err error) (int, string) {
+    switch {
+    case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+        return http.StatusInsufficientStorage, "file storage is full, free up space or grow the volume"
+    case errors.Is(err, syscall.EROFS):
+        return http.StatusServiceUnavailable, "file storage is read-only, check the uploads volume mount"
+    case errors.Is(err, errStorageUnavailable), errors.Is(err, syscall.EIO),
+        errors.Is(err, syscall.ENOTCONN), errors.Is(err, syscall.ESTALE), errors.Is(err, syscall.ENODEV):
+        return http.StatusServiceUnavailable, "file storage is unavailable, check the uploads volume or bucket"
+    }
+    // Not net.Error: syscall.Errno satisfies that interface too
+    var opErr *net.OpError
+    var dnsErr *net.DNSError
+    var urlErr *url.Error
+    if errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.As(err, &urlErr) {
+        return http.StatusServiceUnavailable, "file storage is unreachable, check the bucket endpoint"
+    }
+    return http.StatusInternalServerError, err.Error()
+}
+
+// writeStorageError answers a failed storage operation, logging outages so
+// they stand out from ordinary errors
+func writeStorageError(w http.ResponseWriter, err error) {
+    status, message := storageErrorStatus(err)
+    if status != http.StatusInternalServerError {
+        log.Printf("Storage problem (%d): %v", status, err)
+        message += ": " + err.Error()
+    }
+    if status == http.StatusServiceUnavailable {
+        w.Header().Set("Retry-After", "30")
+    }
+    writeError(w, status, message)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// Tag is a label shared between todos. It serialises as its bare name so
+// todos carry "tags": ["work", "sprint-12"].
+type Tag struct {
+    ID   uint   `json:"-"`
+    Name string `json:"name" gorm:"uniqueIndex;not null"`
+}
+
+func (t Tag) MarshalJSON() ([]byte, error) {
+    return json.Marshal(t.Name)
+}
+
+func (t *Tag) UnmarshalJSON(data []byte) error {
+    if err := json.Unmarshal(data, &t.Name); err != nil {
+        return errors.New("tags must be an array of strings")
+    }
+    return nil
+}
+
+// Longest tag name accepted, in characters
+const maxTagLength = 50
+
+// normalizeTagName lowercases a tag and collapses its whitespace, so "Work",
+// "work" and " work " are the same tag
+func normalizeTagName(name string) (string, error) {
+    name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+    for _, c := range name {
+        if unicode.IsControl(c) {
+            return "", fmt.Errorf("tag %q must not contain control characters", name)
+        }
+    }
+    if utf8.RuneCountInString(name) > maxTagLength {
+        return "", fmt.Errorf("tag %q is longer than %d characters", name, maxTagLength)
+    }
+    return name, nil
+}
+
+// cleanTagNames normalizes and de-duplicates tag names, dropping empty ones
+func cleanTagNames(names []string) ([]string, error) {
+    seen := map[string]bool{}
+    var cleaned []string
+    for _, name := range names {
+        name, err := normalizeTagName(name)
+        if err != nil {
+            return nil, err
+        }
+        if name == "" || seen[name] {
+            continue
+        }
+        seen[name] = true
+        cleaned = append(cleaned, name)
+    }
+    return cleaned, nil
+}
+
+// normalizeExistingTags brings tags created before normalization in line,
+// merging the ones that normalize to the same name. Safe to run every start.
+func normalizeExistingTags(db *gorm.DB) error {
+    var tags []Tag
+    if err := db.Order("id").Find(&tags).Error; err != nil {
+        return err
+    }
+    for _, tag := range tags {
+        name, err := normalizeTagName(tag.Name)
+        if err != nil || name == tag.Name {
+            // Invalid legacy names are left for a human to sort out
+            continue
+        }
+        err = db.Transaction(func(tx *gorm.DB) error {
+            var target Tag
+            err := tx.Where("name = ?", name).First(&target).Error
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return tx.Model(&tag).Update("name", name).Error
+            }
+            if err != nil {
+                return err
+            }
+            // Move the links over to the surviving tag, then drop this one
+            err = tx.Exec("INSERT INTO todo_tags (todo_id, tag_id) SELECT todo_id, ? FROM todo_tags WHERE tag_id = ? ON CONFLICT DO NOTHING", target.ID, tag.ID).Error
+            if err != nil {
+                return err
+            }
+            if err := tx.Exec("DELETE FROM todo_tags WHERE tag_id = ?", tag.ID).Error; err != nil {
+                return err
+            }
+            return tx.Delete(&tag).Error
+        })
+        if err != nil {
+            return fmt.Errorf("normalizing tag %q: %v", tag.Name, err)
+        }
+    }
+    return nil
+}
+
+func tagNames(tags []Tag) []string {
+    names := make([]string, len(tags))
+    for i, tag := range tags {
+        names[i] = tag.Name
+    }
+    return names
+}
+
+// findOrCreateTags returns the tag rows for names, creating missing ones
+func findOrCreateTags(tx *gorm.DB, names []string) ([]Tag, error) {
+    names, err := cleanTagNames(names)
+    if err != nil {
+        return nil, err
+    }
+    if len(names) == 0 {
+        return nil, nil
+    }
+
+    tags := make([]Tag, len(names))
+    for i, name := range names {
+        tags[i] = Tag{Name: name}
+    }
+    err = tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).Create(&tags).Error
+    if err != nil {
+        return nil, err
+    }
+
+    tags = nil
+    if err := tx.Where("name IN ?", names).Find(&tags).Error; err != nil {
+        return nil, err
+    }
+    return tags, nil
+}
+
+// setTodoTags replaces a todo's tags with the named ones
+func setTodoTags(tx *gorm.DB, todo *Todo, names []string) error {
+    tags, err := findOrCreateTags(tx, names)
+    if err != nil {
+        return err
+    }
+    todo.Tags = tags
+    return tx.Model(todo).Association("Tags").Replace(tags)
+}
+
+// bulkTagTodos adds and removes tags on many todos in one transaction
+func (s *Server) bulkTagTodos(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        UUIDs  []string `json:"uuids"`
+        Add    []string `json:"add"`
+        Remove []string `json:"remove"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(body.UUIDs) == 0 {
+        writeError(w, http.StatusBadRequest, "uuids must not be empty")
+        return
+    }
+    if len(body.UUIDs) > maxBatchUUIDs {
+        writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d todos can be tagged at once", maxBatchUUIDs))
+        return
+    }
+    add, err := cleanTagNames(body.Add)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    remove, err := cleanTagNames(body.Remove)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if len(add) == 0 && len(remove) == 0 {
+        writeError(w, http.StatusBadRequest, "add or remove must list at least one tag")
+        return
+    }
+
+    var updated int
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        var ids []uint
+        if err := tx.Model(&Todo{}).Where("uuid IN ?", body.UUIDs).Pluck("id", &ids).Error; err != nil {
+            return err
+        }
+        updated = len(ids)
+        if len(ids) == 0 {
+            return nil
+        }
+
+        if len(remove) > 0 {
+            err := tx.Exec("DELETE FROM todo_tags WHERE todo_id IN ? AND tag_id IN (SELECT id FROM tags WHERE name IN ?)", ids, remove).Error
+            if err != nil {
+                return err
+            }
+        }
+
+        if len(add) > 0 {
+            tags, err := findOrCreateTags(tx, add)
+            if err != nil {
+                return err
+            }
+            var rows []map[string]interface{}
+            for _, id := range ids {
+                for _, tag := range tags {
+                    rows = append(rows, map[string]interface{}{"todo_id": id, "tag_id": tag.ID})
+                }
+            }
+            return tx.Table("todo_tags").Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+        }
+        return nil
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]int{"updated": updated})
+}
+
+// loadTags fills in the tags for a batch of todos with a single query, for
+// code paths that can't use Preload such as row cursors.
+func loadTags(tx *gorm.DB, todos []Todo) error {
+    if len(todos) == 0 {
+        return nil
+    }
+
+    index := make(map[jsonID]int, len(todos))
+    ids := make([]jsonID, len(todos))
+    for i, todo := range todos {
+        index[todo.ID] = i
+        ids[i] = todo.ID
+        todos[i].Tags = []Tag{}
+    }
+
+    var links []struct {
+        TodoID jsonID
+        TagID  uint
+        Name   string
+    }
+    err := tx.Table("todo_tags").
+        Select("todo_tags.todo_id, tags.id AS tag_id, tags.name").
+        Joins("JOIN tags ON tags.id = todo_tags.tag_id").
+        Where("todo_tags.todo_id IN ?", ids).
+        Order("tags.name").
+        Scan(&links).Error
+    if err != nil {
+        return err
+    }
+
+    for _, link := range links {
+        i := index[link.TodoID]
+        todos[i].Tags = append(todos[i].Tags, Tag{ID: link.TagID, Name: link.Name})
+    }
+    return nil
+}
+
+type tagCount struct {
+    Tag   string `json:"tag"`
+    Count int64  `json:"count"`
+}
+
+// listTagCounts returns every tag with the number of live todos using it,
+// most used first
+func (s *Server) listTagCounts(w http.ResponseWriter, r *http.Request) {
+    counts := []tagCount{}
+    err := s.withReadRetry(func() error {
+        return s.db.Table("tags").
+            Select("tags.name AS tag, COUNT(todos.id) AS count").
+            Joins("LEFT JOIN todo_tags ON todo_tags.tag_id = tags.id").
+            Joins("LEFT JOIN todos ON todos.id = todo_tags.todo_id AND todos.deleted_at IS NULL").
+            Group("tags.id, tags.name").
+            Order("count DESC, tags.name").
+            Scan(&counts).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, counts)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Template is a named, reusable set of todos such as an onboarding checklist.
+// Items hold descriptions, so they are sealed with FIELD_ENCRYPTION_KEY too.
+type Template struct {
+    Model
+    Name  string         `json:"name" gorm:"uniqueIndex;not null"`
+    Items []TemplateItem `json:"items" gorm:"serializer:encryptedjson"`
+}
+
+type TemplateItem struct {
+    Title       string   `json:"title"`
+    Description string   `json:"description"`
+    Tags        []string `json:"tags,omitempty"`
+}
+
+// Placeholders like {{date}} or {{ project }} in template titles/descriptions
+var templateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// expandTemplate substitutes known variables, leaving unknown ones untouched
+func expandTemplate(text string, variables map[string]string) string {
+    return templateVariable.ReplaceAllStringFunc(text, func(match string) string {
+        name := templateVariable.FindStringSubmatch(match)[1]
+        if value, ok := variables[name]; ok {
+            return value
+        }
+        return match
+    })
+}
+
+func (s *Server) createTemplate(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        Name  string         `json:"name"`
+        Items []TemplateItem `json:"items"`
+        // Snapshot existing todos instead of (or as well as) listing items
+        UUIDs []string `json:"uuids"`
+    }
+    if err := decodeJSON(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    body.Name = strings.TrimSpace(body.Name)
+    if body.Name == "" || len(body.Name) > 100 || strings.Contains(body.Name, "/") {
+        writeError(w, http.StatusBadRequest, "name is required, must be at most 100 characters and must not contain '/'")
+        return
+    }
+
+    template := Template{Name: body.Name, Items: body.Items}
+    if len(body.UUIDs) > 0 {
+        var todos []Todo
+        if err := s.db.Preload("Tags").Where("uuid IN ?", body.UUIDs).Order("id").Find(&todos).Error; err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        requested := map[string]bool{}
+        for _, id := range body.UUIDs {
+            requested[id] = true
+        }
+        if len(todos) != len(requested) {
+            writeError(w, http.StatusNotFound, "one or more todos in uuids were not found")
+            return
+        }
+        for _, todo := range todos {
+            template.Items = append(template.Items, TemplateItem{
+                Title:       todo.Title,
+                Description: todo.Description,
+                Tags:        tagNames(todo.Tags),
+            })
+        }
+    }
+
+    if len(template.Items) == 0 {
+        writeError(w, http.StatusBadRequest, "a template needs at least one item")
+        return
+    }
+    for _, item := range template.Items {
+        if strings.TrimSpace(item.Title) == "" {
+            writeError(w, http.StatusBadRequest, "every template item needs a title")
+            return
+        }
+        if _, err := cleanTagNames(item.Tags); err != nil {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        if err := s.checkDescription(item.Description); err != nil {
+            writeError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+    }
+
+    result := s.db.Create(&template)
+    if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+        writeError(w, http.StatusConflict, "a template with this name already exists")
+        return
+    }
+    if result.Error != nil {
+        writeError(w, http.StatusInternalServerError, result.Error.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, template)
+}
+
+func (s *Server) listTemplates(w http.ResponseWriter, r *http.Request) {
+    templates := []Template{}
+    if err := s.db.Order("name").Find(&templates).Error; err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, templates)
+}
+
+func (s *Server) findTemplate(w http.ResponseWriter, name string) (*Template, bool) {
+    var template Template
+    err := s.db.Where("name = ?", name).First(&template).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "template not found")
+        return nil, false
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return nil, false
+    }
+    return &template, true
+}
+
+func (s *Server) getTemplate(w http.ResponseWriter, r *http.Request) {
+    template, ok := s.findTemplate(w, mux.Vars(r)["name"])
+    if !ok {
+        return
+    }
+    writeJSON(w, http.StatusOK, template)
+}
+
+func (s *Server) deleteTemplate(w http.ResponseWriter, r *http.Request) {
+    // Hard delete so the name can be reused
+    result := s.db.Unscoped().Where("name = ?", mux.Vars(r)["name"]).Delete(&Template{})
+    if result.Error != nil {
+        writeError(w, http.StatusInternalServerError, result.Error.Error())
+        return
+    }
+    if result.RowsAffected == 0 {
+        writeError(w, http.StatusNotFound, "template not found")
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// instantiateTemplate creates all of a template's todos in one transaction.
+// {{date}} expands to today's date, other variables come from the body.
+func (s *Server) instantiateTemplate(w http.ResponseWriter, r *http.Request) {
+    template, ok := s.findTemplate(w, mux.Vars(r)["name"])
+    if !ok {
+        return
+    }
+
+    var body struct {
+        Variables map[string]string `json:"variables"`
+    }
+    // The body is optional when no custom variables are needed
+    if err := decodeJSON(r, &body); err != nil && !errors.Is(err, errEmptyBody) {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    variables := map[string]string{"date": time.Now().Format("2006-01-02")}
+    for name, value := range body.Variables {
+        variables[name] = value
+    }
+
+    todos := make([]Todo, len(template.Items))
+    for i, item := range template.Items {
+        todos[i] = Todo{
+            UUID:        s.newTodoUUID(),
+            Title:       expandTemplate(item.Title, variables),
+            Description: expandTemplate(item.Description, variables),
+        }
+        // Variables can make a description grow past the limit
+        if err := s.checkDescription(todos[i].Description); err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("item %d: %v", i, err))
+            return
+        }
+    }
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        for i, item := range template.Items {
+            if err := tx.Create(&todos[i]).Error; err != nil {
+                return err
+            }
+            if len(item.Tags) > 0 {
+                if err := setTodoTags(tx, &todos[i], item.Tags); err != nil {
+                    return err
+                }
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusCreated, todos)
+}
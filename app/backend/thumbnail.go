@@ -0,0 +1,152 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "image"
+    "image/color"
+    _ "image/gif"
+    "image/jpeg"
+    _ "image/png"
+    "io"
+    "io/fs"
+    "log"
+    "net/http"
+    "path/filepath"
+
+    "github.com/gorilla/mux"
+)
+
+// Refuse to decode anything bigger than this many pixels to bound memory use
+const maxThumbnailSourcePixels = 50_000_000
+
+func thumbnailName(fileName string) string {
+    return "thumbnails/" + filepath.Base(fileName) + ".jpg"
+}
+
+// decodeStoredImage decodes an upload, checking its dimensions first so huge
+// images are rejected before any pixels are allocated.
+func (s *Server) decodeStoredImage(fileName string) (image.Image, error) {
+    file, err := s.storage.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    config, _, err := image.DecodeConfig(file)
+    file.Close()
+    if err != nil {
+        return nil, err
+    }
+    if config.Width*config.Height > maxThumbnailSourcePixels {
+        return nil, fmt.Errorf("image too large for thumbnail: %dx%d", config.Width, config.Height)
+    }
+
+    file, err = s.storage.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    src, _, err := image.Decode(file)
+    return src, err
+}
+
+// createThumbnail stores a JPEG thumbnail for the upload. Files that aren't
+// decodable images return an error and simply get no thumbnail.
+func (s *Server) createThumbnail(fileName string) error {
+    src, err := s.decodeStoredImage(fileName)
+    if err != nil {
+        return err
+    }
+
+    var out bytes.Buffer
+    if err := jpeg.Encode(&out, resizeToFit(src, s.config.ThumbnailMaxDim), &jpeg.Options{Quality: 80}); err != nil {
+        return err
+    }
+    return s.storage.Save(thumbnailName(fileName), &out, int64(out.Len()))
+}
+
+// resizeToFit box-filters src down so neither side exceeds maxDim, flattening
+// any transparency onto white since JPEG has no alpha channel.
+func resizeToFit(src image.Image, maxDim int) image.Image {
+    bounds := src.Bounds()
+    srcW, srcH := bounds.Dx(), bounds.Dy()
+
+    dstW, dstH := srcW, srcH
+    if srcW > maxDim || srcH > maxDim {
+        if srcW >= srcH {
+            dstW, dstH = maxDim, srcH*maxDim/srcW
+        } else {
+            dstW, dstH = srcW*maxDim/srcH, maxDim
+        }
+    }
+    if dstW < 1 {
+        dstW = 1
+    }
+    if dstH < 1 {
+        dstH = 1
+    }
+
+    dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+    for y := 0; y < dstH; y++ {
+        y0 := bounds.Min.Y + y*srcH/dstH
+        y1 := bounds.Min.Y + (y+1)*srcH/dstH
+        if y1 == y0 {
+            y1++
+        }
+        for x := 0; x < dstW; x++ {
+            x0 := bounds.Min.X + x*srcW/dstW
+            x1 := bounds.Min.X + (x+1)*srcW/dstW
+            if x1 == x0 {
+                x1++
+            }
+
+            var r, g, b, a, n uint64
+            for sy := y0; sy < y1; sy++ {
+                for sx := x0; sx < x1; sx++ {
+                    cr, cg, cb, ca := src.At(sx, sy).RGBA()
+                    r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+                    n++
+                }
+            }
+
+            // Colours are alpha-premultiplied, so compositing over white is an add
+            white := 0xffff - a/n
+            dst.Set(x, y, color.RGBA64{
+                R: uint16(r/n + white),
+                G: uint16(g/n + white),
+                B: uint16(b/n + white),
+                A: 0xffff,
+            })
+        }
+    }
+    return dst
+}
+
+func (s *Server) generateThumbnail(fileName string) bool {
+    if err := s.createThumbnail(fileName); err != nil {
+        if err != image.ErrFormat {
+            log.Printf("Failed to create thumbnail for %s: %v", fileName, err)
+        }
+        return false
+    }
+    return true
+}
+
+func (s *Server) getThumbnail(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    fileName := vars["filename"]
+
+    file, err := s.storage.Open(thumbnailName(fileName))
+    if errors.Is(err, fs.ErrNotExist) {
+        writeError(w, http.StatusNotFound, "Thumbnail not found")
+        return
+    }
+    if err != nil {
+        writeStorageError(w, err)
+        return
+    }
+    defer file.Close()
+
+    w.Header().Set("Content-Type", "image/jpeg")
+    io.Copy(w, file)
+}
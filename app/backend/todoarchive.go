@@ -0,0 +1,98 @@
+package main
+
+import (
+    "archive/zip"
+    "compress/flate"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// downloadTodoArchive streams a zip with the todo as todo.json and its files
+// under attachments/, built on the fly. Files missing from storage are left
+// out rather than failing the half-written download.
+func (s *Server) downloadTodoArchive(w http.ResponseWriter, r *http.Request) {
+    todoUUID := mux.Vars(r)["uuid"]
+
+    var todo Todo
+    err := s.withReadRetry(func() error {
+        return s.db.Preload("Tags").
+            Preload("Attachments", func(tx *gorm.DB) *gorm.DB {
+                return tx.Order("id")
+            }).
+            Where("uuid = ?", todoUUID).First(&todo).Error
+    })
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "todo not found")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    // The single file_path upload and the attachments, without duplicates
+    var names []string
+    seen := map[string]bool{}
+    if todo.FilePath != "" {
+        names = append(names, storedName(todo.FilePath))
+        seen[names[0]] = true
+    }
+    for _, attachment := range todo.Attachments {
+        if !seen[attachment.Name] {
+            names = append(names, attachment.Name)
+            seen[attachment.Name] = true
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=todo-%s.zip", todo.UUID))
+
+    archive := zip.NewWriter(w)
+    archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+        return flate.NewWriter(out, s.config.GzipLevel)
+    })
+    entry, err := archive.Create("todo.json")
+    if err == nil {
+        encoder := json.NewEncoder(entry)
+        encoder.SetIndent("", "  ")
+        err = encoder.Encode(todo)
+    }
+    for _, name := range names {
+        if err != nil {
+            break
+        }
+        err = s.addToArchive(archive, "attachments/"+name, name)
+    }
+    if err == nil {
+        err = archive.Close()
+    }
+    if err != nil {
+        // Headers are gone, all we can do is stop and log
+        log.Printf("Archive of todo %s failed: %v", todo.UUID, err)
+    }
+}
+
+// addToArchive copies a stored file into the zip. Only write errors are
+// returned, a file that can't be read is logged and skipped.
+func (s *Server) addToArchive(archive *zip.Writer, path, name string) error {
+    file, err := s.storage.Open(name)
+    if err != nil {
+        log.Printf("Archive: skipping %s: %v", name, err)
+        return nil
+    }
+    defer file.Close()
+
+    entry, err := archive.Create(path)
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(entry, file)
+    return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// Longest owner id accepted by the transfer endpoint
+const maxOwnerIDLength = 255
+
+// OwnershipTransfer is the audit record of one change of a todo's owner
+type OwnershipTransfer struct {
+    ID        uint      `json:"-" gorm:"primarykey"`
+    CreatedAt time.Time `json:"created_at"`
+    TodoUUID  string    `json:"todo_uuid" gorm:"index"`
+    FromOwner string    `json:"from_owner"`
+    ToOwner   string    `json:"to_owner"`
+    ClientIP  string    `json:"client_ip"`
+}
+
+// transferTodo reassigns a todo to {"new_owner": "..."}. Owners are opaque
+// ids managed outside this service; there is no owner scoping of the CRUD
+// routes yet, so this only records who a todo belongs to. Each transfer is
+// recorded as an OwnershipTransfer in the same transaction.
+func (s *Server) transferTodo(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        NewOwner string `json:"new_owner"`
+    }
+    if err := decodeJSONStrict(r, &body); err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    newOwner := strings.TrimSpace(body.NewOwner)
+    if newOwner == "" {
+        writeError(w, http.StatusBadRequest, "new_owner is required")
+        return
+    }
+    if len(newOwner) > maxOwnerIDLength {
+        writeError(w, http.StatusBadRequest, "new_owner is too long")
+        return
+    }
+
+    var todo Todo
+    var previous string
+    err := s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Preload("Tags").Where("uuid = ?", mux.Vars(r)["uuid"]).First(&todo).Error; err != nil {
+            return err
+        }
+        previous = todo.OwnerID
+        if previous == newOwner {
+            return nil
+        }
+        todo.OwnerID = newOwner
+        if err := tx.Model(&todo).Update("owner_id", newOwner).Error; err != nil {
+            return err
+        }
+        return tx.Create(&OwnershipTransfer{
+            TodoUUID:  todo.UUID,
+            FromOwner: previous,
+            ToOwner:   newOwner,
+            ClientIP:  clientIP(r),
+        }).Error
+    })
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "todo not found")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, todo)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+    "errors"
+    "io/fs"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/mux"
+    "gorm.io/gorm"
+)
+
+// TrashedFile is the metadata of a file deleted with DELETE /files/{filename}
+// while FILE_TRASH_DAYS is set. The content sits under trash/ in storage until
+// it is restored or purged. It is kept apart from File so a new upload can
+// reuse the name in the meantime.
+type TrashedFile struct {
+    ID          uint       `json:"-" gorm:"primarykey"`
+    Name        string     `json:"name" gorm:"uniqueIndex"`
+    Size        int64      `json:"size"`
+    ContentType string     `json:"content_type"`
+    SHA256      string     `json:"sha256,omitempty" gorm:"column:sha256"`
+    // The todos it was attached to, linked again on restore
+    TodoIDs     []jsonID   `json:"-" gorm:"serializer:json"`
+    Folder      string     `json:"folder,omitempty" gorm:"not null;default:''"`
+    UploadedAt  time.Time  `json:"uploaded_at"`
+    TrashedAt   time.Time  `json:"trashed_at" gorm:"index"`
+    // When the purge job will delete it for good
+    PurgeAt     *time.Time `json:"purge_at,omitempty" gorm:"-"`
+}
+
+func trashName(fileName string) string {
+    return "trash/" + fileName
+}
+
+// trashFile moves an upload into the trash, replacing an older trashed file
+// of the same name. The thumbnail is dropped and regenerated on restore.
+func (s *Server) trashFile(fileName string) error {
+    info, err := s.storage.Stat(fileName)
+    if err != nil {
+        return err
+    }
+
+    trashed := TrashedFile{Name: fileName, Size: info.Size, UploadedAt: info.ModTime, TrashedAt: time.Now()}
+    var record File
+    err = s.db.Where("name = ?", fileName).First(&record).Error
+    if err == nil {
+        trashed.Size = record.Size
+        trashed.ContentType = record.ContentType
+        trashed.SHA256 = record.SHA256
+        err := s.db.Table("file_attachments").Where("file_id = ?", record.ID).Order("todo_id").Pluck("todo_id", &trashed.TodoIDs).Error
+        if err != nil {
+            return err
+        }
+        trashed.Folder = record.Folder
+        trashed.UploadedAt = record.CreatedAt
+    } else if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return err
+    }
+
+    if err := s.storage.Rename(fileName, trashName(fileName)); err != nil {
+        return err
+    }
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("name = ?", fileName).Delete(&TrashedFile{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Create(&trashed).Error; err != nil {
+            return err
+        }
+        return deleteFileRecord(tx, fileName)
+    })
+    if err != nil {
+        // Put the content back so the file isn't lost between the two
+        s.storage.Rename(trashName(fileName), fileName)
+        return err
+    }
+
+    if err := s.storage.Delete(thumbnailName(fileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+        log.Printf("Failed to delete thumbnail for %s: %v", fileName, err)
+    }
+    return nil
+}
+
+func (s *Server) listTrash(w http.ResponseWriter, r *http.Request) {
+    trashed := []TrashedFile{}
+    err := s.withReadRetry(func() error {
+        return s.db.Order("trashed_at DESC").Find(&trashed).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    if s.config.FileTrashRetention > 0 {
+        for i := range trashed {
+            purgeAt := trashed[i].TrashedAt.Add(s.config.FileTrashRetention)
+            trashed[i].PurgeAt = &purgeAt
+        }
+    }
+    writeJSON(w, http.StatusOK, trashed)
+}
+
+// restoreFile moves a trashed file back under its original name, unless a
+// new upload has taken that name since
+func (s *Server) restoreFile(w http.ResponseWriter, r *http.Request) {
+    fileName := mux.Vars(r)["filename"]
+
+    var trashed TrashedFile
+    err := s.db.Where("name = ?", fileName).First(&trashed).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        writeError(w, http.StatusNotFound, "file is not in the trash")
+        return
+    }
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    if _, err := s.storage.Stat(fileName); err == nil {
+        writeError(w, http.StatusConflict, "a file with this name exists, rename or delete it first")
+        return
+    } else if !errors.Is(err, fs.ErrNotExist) {
+        writeStorageError(w, err)
+        return
+    }
+
+    if err := s.storage.Rename(trashName(fileName), fileName); err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            writeError(w, http.StatusNotFound, "trashed file content is missing")
+            return
+        }
+        writeStorageError(w, err)
+        return
+    }
+    err = s.db.Transaction(func(tx *gorm.DB) error {
+        record := File{
+            Name:        trashed.Name,
+            Size:        trashed.Size,
+            ContentType: trashed.ContentType,
+            SHA256:      trashed.SHA256,
+            Folder:      trashed.Folder,
+            CreatedAt:   trashed.UploadedAt,
+        }
+        if err := tx.Create(&record).Error; err != nil {
+            return err
+        }
+        if len(trashed.TodoIDs) > 0 {
+            err := tx.Exec("INSERT INTO file_attachments (todo_id, file_id) SELECT id, ? FROM todos WHERE id IN ? ON CONFLICT DO NOTHING", record.ID, trashed.TodoIDs).Error
+            if err != nil {
+                return err
+            }
+        }
+        return tx.Delete(&trashed).Error
+    })
+    if err != nil {
+        s.storage.Rename(fileName, trashName(fileName))
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    response := map[string]string{"file_path": s.storage.Location(fileName)}
+    if s.generateThumbnail(fileName) {
+        response["thumbnail"] = fileName
+    }
+    writeJSON(w, http.StatusOK, response)
+}
+
+// trashPending counts the trashed files the next purge would delete
+func (s *Server) trashPending() (int64, error) {
+    var count int64
+    cutoff := time.Now().Add(-s.config.FileTrashRetention)
+    err := s.db.Model(&TrashedFile{}).Where("trashed_at < ?", cutoff).Count(&count).Error
+    return count, err
+}
+
+// purgeTrash permanently deletes files trashed more than FILE_TRASH_DAYS ago
+func (s *Server) purgeTrash() error {
+    if s.readOnly.Load() {
+        log.Println("Trash: skipped, server is read-only")
+        return nil
+    }
+
+    var expired []TrashedFile
+    cutoff := time.Now().Add(-s.config.FileTrashRetention)
+    if err := s.db.Where("trashed_at < ?", cutoff).Find(&expired).Error; err != nil {
+        return err
+    }
+    for _, trashed := range expired {
+        if err := s.storage.Delete(trashName(trashed.Name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+            return err
+        }
+        if err := s.db.Delete(&trashed).Error; err != nil {
+            return err
+        }
+    }
+    if len(expired) > 0 {
+        log.Printf("Trash: purged %d files", len(expired))
+    }
+    return nil
+}
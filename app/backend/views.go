@@ -0,0 +1,132 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+const (
+    defaultGroupLimit = 100
+    maxGroupLimit     = 500
+
+    // Sized for an activity feed widget
+    defaultRecentLimit = 10
+    maxRecentLimit     = 50
+
+    // Look-ahead for the upcoming widget, in days
+    defaultUpcomingDays = 7
+    maxUpcomingDays     = 365
+)
+
+// parseLimit reads ?limit=, falling back to def and rejecting values over max
+func parseLimit(r *http.Request, def, max int) (int, error) {
+    value := r.URL.Query().Get("limit")
+    if value == "" {
+        return def, nil
+    }
+    limit, err := strconv.Atoi(value)
+    if err != nil || limit < 1 || limit > max {
+        return 0, fmt.Errorf("limit must be between 1 and %d", max)
+    }
+    return limit, nil
+}
+
+// getGroupedTodos returns pending and completed todos as separate lists,
+// each capped at ?limit= with the full count alongside.
+func (s *Server) getGroupedTodos(w http.ResponseWriter, r *http.Request) {
+    filters, err := s.parseTodoFilters(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    limit, err := parseLimit(r, defaultGroupLimit, maxGroupLimit)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    groups := map[string][]Todo{}
+    counts := map[string]int64{}
+    for name, completed := range map[string]bool{"pending": false, "completed": true} {
+        scope := func(tx *gorm.DB) *gorm.DB {
+            return tx.Scopes(filters).Where("completed = ?", completed)
+        }
+
+        todos := []Todo{}
+        var count int64
+        err := s.withReadRetry(func() error {
+            if err := s.db.Model(&Todo{}).Scopes(scope).Count(&count).Error; err != nil {
+                return err
+            }
+            return s.db.Preload("Tags").Scopes(scope).Order("id").Limit(limit).Find(&todos).Error
+        })
+        if err != nil {
+            writeError(w, http.StatusInternalServerError, err.Error())
+            return
+        }
+        groups[name] = todos
+        counts[name] = count
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "pending":   groups["pending"],
+        "completed": groups["completed"],
+        "counts":    counts,
+        "limit":     limit,
+    })
+}
+
+// getRecentTodos returns the most recently updated todos, completed or not,
+// newest first.
+func (s *Server) getRecentTodos(w http.ResponseWriter, r *http.Request) {
+    limit, err := parseLimit(r, defaultRecentLimit, maxRecentLimit)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    todos := []Todo{}
+    err = s.withReadRetry(func() error {
+        return s.db.Preload("Tags").Order("updated_at DESC, id DESC").Limit(limit).Find(&todos).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, todos)
+}
+
+// getUpcomingTodos returns incomplete todos due between now and ?days= from
+// now, soonest first. Archived todos are left out like on the list.
+func (s *Server) getUpcomingTodos(w http.ResponseWriter, r *http.Request) {
+    days := defaultUpcomingDays
+    if value := r.URL.Query().Get("days"); value != "" {
+        parsed, err := strconv.Atoi(value)
+        if err != nil || parsed < 1 || parsed > maxUpcomingDays {
+            writeError(w, http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxUpcomingDays))
+            return
+        }
+        days = parsed
+    }
+
+    now := time.Now()
+    todos := []Todo{}
+    err := s.withReadRetry(func() error {
+        return s.db.Preload("Tags").
+            Where("completed = ? AND archived = ?", false, false).
+            Where("due_date >= ? AND due_date < ?", now, now.AddDate(0, 0, days)).
+            Order("due_date, id").
+            Find(&todos).Error
+    })
+    if err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    writeJSON(w, http.StatusOK, todos)
+}
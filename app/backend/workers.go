@@ -0,0 +1,183 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// A worker is reported unhealthy once it goes this many intervals without
+// finishing a run
+const workerStaleIntervals = 2
+
+// worker tracks one background job started with startWorker
+type worker struct {
+    name     string
+    interval time.Duration
+    started  time.Time
+    // Counts the items waiting for the next run
+    pending  func() (int64, error)
+
+    mu           sync.Mutex
+    running      bool
+    lastRun      time.Time
+    lastDuration time.Duration
+    lastErr      error
+    runs         int64
+    failures     int64
+}
+
+type workerStatus struct {
+    Name         string     `json:"name"`
+    Interval     string     `json:"interval"`
+    Running      bool       `json:"running"`
+    LastRun      *time.Time `json:"last_run"`
+    LastDuration string     `json:"last_duration,omitempty"`
+    LastError    string     `json:"last_error,omitempty"`
+    Runs         int64      `json:"runs"`
+    Failures     int64      `json:"failures"`
+    QueueDepth   *int64     `json:"queue_depth"`
+    QueueError   string     `json:"queue_error,omitempty"`
+    Healthy      bool       `json:"healthy"`
+}
+
+func (wk *worker) status(now time.Time) workerStatus {
+    // Counted before taking the lock, it is a database query
+    depth, depthErr := wk.pending()
+
+    wk.mu.Lock()
+    defer wk.mu.Unlock()
+
+    status := workerStatus{
+        Name:     wk.name,
+        Interval: wk.interval.String(),
+        Running:  wk.running,
+        Runs:     wk.runs,
+        Failures: wk.failures,
+    }
+    since := wk.started
+    if !wk.lastRun.IsZero() {
+        lastRun := wk.lastRun
+        status.LastRun = &lastRun
+        status.LastDuration = wk.lastDuration.String()
+        since = wk.lastRun
+    }
+    if wk.lastErr != nil {
+        status.LastError = wk.lastErr.Error()
+    }
+    if depthErr != nil {
+        status.QueueError = depthErr.Error()
+    } else {
+        status.QueueDepth = &depth
+    }
+    status.Healthy = now.Sub(since) <= workerStaleIntervals*wk.interval
+    return status
+}
+
+// startWorker runs fn now and then every interval in the background, keeping
+// track of each run for GET /admin/workers. pending counts the work waiting
+// for the next run and is reported as the worker's queue depth.
+func (s *Server) startWorker(name string, interval time.Duration, fn func() error, pending func() (int64, error)) {
+    wk := &worker{name: name, interval: interval, started: time.Now(), pending: pending}
+    s.workersMu.Lock()
+    s.workers = append(s.workers, wk)
+    s.workersMu.Unlock()
+
+    go func() {
+        for {
+            wk.mu.Lock()
+            wk.running = true
+            wk.mu.Unlock()
+
+            start := time.Now()
+            err := fn()
+
+            wk.mu.Lock()
+            wk.running = false
+            wk.lastRun = time.Now()
+            wk.lastDuration = wk.lastRun.Sub(start)
+            wk.lastErr = err
+            wk.runs++
+            if err != nil {
+                wk.failures++
+            }
+            wk.mu.Unlock()
+
+            time.Sleep(interval)
+        }
+    }()
+}
+
+func (s *Server) workerStatuses() []workerStatus {
+    s.workersMu.Lock()
+    workers := append([]*worker(nil), s.workers...)
+    s.workersMu.Unlock()
+
+    now := time.Now()
+    statuses := make([]workerStatus, len(workers))
+    for i, wk := range workers {
+        statuses[i] = wk.status(now)
+    }
+    return statuses
+}
+
+// listWorkers reports the background workers, answering 503 when any of them
+// looks stuck so it can double as a probe
+func (s *Server) listWorkers(w http.ResponseWriter, r *http.Request) {
+    statuses := s.workerStatuses()
+    healthy := true
+    for _, status := range statuses {
+        healthy = healthy && status.Healthy
+    }
+
+    status := http.StatusOK
+    if !healthy {
+        status = http.StatusServiceUnavailable
+    }
+    writeJSON(w, status, map[string]interface{}{"healthy": healthy, "workers": statuses})
+}
+
+// workerMetrics reports the same figures in the Prometheus text format, for
+// scraping rather than polling /admin/workers
+func (s *Server) workerMetrics(w http.ResponseWriter, r *http.Request) {
+    statuses := s.workerStatuses()
+    var b strings.Builder
+    metric := func(name, kind, help string, value func(workerStatus) (float64, bool)) {
+        fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+        for _, status := range statuses {
+            if v, ok := value(status); ok {
+                fmt.Fprintf(&b, "%s{worker=%q} %g\n", name, status.Name, v)
+            }
+        }
+    }
+    metric("todo_worker_runs_total", "counter", "Completed runs of the background worker.", func(st workerStatus) (float64, bool) {
+        return float64(st.Runs), true
+    })
+    metric("todo_worker_failures_total", "counter", "Runs of the background worker that returned an error.", func(st workerStatus) (float64, bool) {
+        return float64(st.Failures), true
+    })
+    metric("todo_worker_queue_depth", "gauge", "Items waiting for the next run of the background worker.", func(st workerStatus) (float64, bool) {
+        if st.QueueDepth == nil {
+            return 0, false
+        }
+        return float64(*st.QueueDepth), true
+    })
+    metric("todo_worker_last_run_timestamp_seconds", "gauge", "Unix time the background worker last finished a run.", func(st workerStatus) (float64, bool) {
+        if st.LastRun == nil {
+            return 0, false
+        }
+        return float64(st.LastRun.Unix()), true
+    })
+    metric("todo_worker_healthy", "gauge", "1 while the background worker runs on schedule, 0 when it looks stuck.", func(st workerStatus) (float64, bool) {
+        if st.Healthy {
+            return 1, true
+        }
+        return 0, true
+    })
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, b.String())
+}